@@ -0,0 +1,149 @@
+package client_pool
+
+import (
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type (
+	// BackoffPolicy decide how long an endpoint is benched after a failure
+	BackoffPolicy interface {
+		// Backoff return the bench duration after the given number of consecutive failures, starting at 1
+		Backoff(failures int, err error) time.Duration
+		// Reset return true if the failure streak should be forgotten after the given consecutive successes
+		Reset(successes int) bool
+	}
+
+	// ExponentialBackoff bench an endpoint for Initial*Multiplier^(failures-1), randomized by Jitter
+	// and capped at Max. The failure streak is reset after ResetAfter consecutive successes
+	ExponentialBackoff struct {
+		Initial    time.Duration
+		Max        time.Duration
+		Multiplier float64
+		// Jitter is the fraction of the delay that is randomized, 0.2 means +/-20%
+		Jitter     float64
+		ResetAfter int
+	}
+
+	// ConstantBackoff always bench an endpoint for the same duration
+	ConstantBackoff time.Duration
+
+	// retryAfterRecorder remember the latest Retry-After header returned by an endpoint
+	retryAfterRecorder struct {
+		mu    sync.Mutex
+		until time.Time
+	}
+
+	retryAfterTransport struct {
+		base     http.RoundTripper
+		recorder *retryAfterRecorder
+	}
+)
+
+// DefaultBackoff return the backoff policy used when Config.Backoff is not set
+func DefaultBackoff() BackoffPolicy {
+	return ExponentialBackoff{
+		Initial:    5 * time.Second,
+		Max:        5 * time.Minute,
+		Multiplier: 2,
+		Jitter:     0.2,
+		ResetAfter: 3,
+	}
+}
+
+func (b ExponentialBackoff) Backoff(failures int, _ error) time.Duration {
+	if failures < 1 {
+		failures = 1
+	}
+	multiplier := b.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	delay := float64(b.Initial) * math.Pow(multiplier, float64(failures-1))
+	if b.Max > 0 && delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		delay += delay * b.Jitter * (2*rand.Float64() - 1)
+	}
+	if b.Max > 0 && delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+	return time.Duration(delay)
+}
+
+func (b ExponentialBackoff) Reset(successes int) bool {
+	return successes >= b.ResetAfter
+}
+
+func (b ConstantBackoff) Backoff(int, error) time.Duration {
+	return time.Duration(b)
+}
+
+func (b ConstantBackoff) Reset(int) bool {
+	return true
+}
+
+func (t *retryAfterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		if delay, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			t.recorder.record(delay)
+		}
+	}
+	return resp, nil
+}
+
+// CloseIdleConnections close the idle connections of the base transport, so closing the client release them
+func (t *retryAfterTransport) CloseIdleConnections() {
+	if base, ok := t.base.(interface{ CloseIdleConnections() }); ok {
+		base.CloseIdleConnections()
+	}
+}
+
+func (r *retryAfterRecorder) record(delay time.Duration) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.until = time.Now().Add(delay)
+}
+
+// remaining return how long the endpoint asked us to wait, zero if there is no pending Retry-After
+func (r *retryAfterRecorder) remaining() time.Duration {
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if d := time.Until(r.until); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// parseRetryAfter parse a Retry-After header value, either delay in seconds or an HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(value)
+	if err != nil || !date.After(now) {
+		return 0, false
+	}
+	return date.Sub(now), true
+}
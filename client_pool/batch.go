@@ -0,0 +1,196 @@
+package client_pool
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/duongtuttbn/toolkit/log"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/pkg/errors"
+)
+
+type (
+	// BatchError hold the errors of the batch items that failed, keyed by item.
+	// It is returned along with the results of the items that succeeded
+	BatchError[K comparable] map[K]error
+
+	blockTimeResult struct {
+		Timestamp hexutil.Uint64 `json:"timestamp"`
+	}
+)
+
+const defaultMaxBatchSize = 100
+
+func (e BatchError[K]) Error() string {
+	if len(e) == 0 {
+		return "no batch item failed"
+	}
+	// the keys are sorted by their text so the same item is reported on every call
+	keys := make([]K, 0, len(e))
+	for key := range e {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b K) int {
+		return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+	})
+	return fmt.Sprintf("%d batch items failed, %v: %v", len(e), keys[0], e[keys[0]])
+}
+
+// BatchCall send the elements as JSON-RPC batches, split according to the batch size limit of each endpoint.
+// When a batch fails, it is retried on another client. When only some elements fail with a retryable error class
+// or a missing response, only those elements are retried. Other element errors are left in their Error field.
+// It returns an error if ctx is done, or if a whole batch failed with an error that is not retryable,
+// such as an endpoint rejecting batches of a single call. The elements not answered yet are then left untouched
+func (pool *ClientPool) BatchCall(ctx context.Context, elems []rpc.BatchElem) (err error) {
+	ctx, end := pool.startSpan(ctx, "BatchCall", attrBatchSize.Int(len(elems)))
+	defer func() { end(err) }()
+	ctx = withMethod(ctx, batchMethod(elems))
+	pending := make([]int, len(elems))
+	for i := range elems {
+		pending[i] = i
+	}
+	for len(pending) > 0 {
+		client, err := pool.GetClientContext(ctx)
+		if err != nil {
+			return err
+		}
+		size := min(client.maxBatchSize(), len(pending))
+		chunk, rest := pending[:size], pending[size:]
+		batch := make([]rpc.BatchElem, len(chunk))
+		for i, index := range chunk {
+			batch[i] = elems[index]
+			batch[i].Error = nil
+		}
+		// every call of the batch count against the rate limit of the endpoint
+		_, err = callN(ctx, client, len(batch), func(ctx context.Context) (struct{}, error) {
+			return struct{}{}, client.rpcClient.BatchCallContext(ctx, batch)
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			class := pool.Classify(err)
+			if class == ClassBatchTooLarge && size > 1 {
+				log.FromContext(ctx).Infof("Batch of %d calls too large for endpoint %s, halve its batch size", size, client.Label())
+				client.setMaxBatchSize(size / 2)
+				continue
+			}
+			if !class.Retryable() {
+				return errors.Wrapf(err, "batch of %d calls on endpoint %s failed", size, client.Label())
+			}
+			client.MarkError(err)
+			log.FromContext(ctx).Errorf("Batch of %d calls on endpoint %s error: %v", size, client.Label(), err)
+			continue
+		}
+
+		var retry []int
+		var retryErr error
+		for i, index := range chunk {
+			elems[index] = batch[i]
+			if batch[i].Error != nil && (pool.retryable(batch[i].Error) || errors.Is(batch[i].Error, rpc.ErrMissingBatchResponse)) {
+				retry = append(retry, index)
+				retryErr = batch[i].Error
+			}
+		}
+		if retryErr != nil {
+			client.MarkError(retryErr)
+			log.FromContext(ctx).Errorf("%d of %d batch calls on endpoint %s failed, retry them: %v", len(retry), size, client.Label(), retryErr)
+		} else {
+			client.MarkSuccess()
+		}
+		pending = append(retry, rest...)
+	}
+	return nil
+}
+
+// BlockTimes return the timestamps of the given blocks, fetching the headers that are not cached in batches.
+// Blocks that could not be fetched are reported in a BatchError
+func (pool *ClientPool) BlockTimes(ctx context.Context, blockNumbers []uint64) (_ map[uint64]uint64, err error) {
+	ctx, end := pool.startSpan(ctx, "BlockTimes", attrBatchSize.Int(len(blockNumbers)))
+	defer func() { end(err) }()
+	blockTimes := make(map[uint64]uint64, len(blockNumbers))
+	var missing []uint64
+	for _, blockNumber := range blockNumbers {
+		if blockTime, ok := pool.headers.blockTime(blockNumber); ok {
+			blockTimes[blockNumber] = blockTime
+		} else {
+			missing = append(missing, blockNumber)
+		}
+	}
+	if len(missing) == 0 {
+		return blockTimes, nil
+	}
+
+	results := make([]*blockTimeResult, len(missing))
+	elems := make([]rpc.BatchElem, len(missing))
+	for i, blockNumber := range missing {
+		elems[i] = rpc.BatchElem{
+			Method: "eth_getBlockByNumber",
+			Args:   []interface{}{hexutil.EncodeUint64(blockNumber), false},
+			Result: &results[i],
+		}
+	}
+	if err := pool.BatchCall(ctx, elems); err != nil {
+		return nil, err
+	}
+	batchErr := BatchError[uint64]{}
+	for i, blockNumber := range missing {
+		switch {
+		case elems[i].Error != nil:
+			batchErr[blockNumber] = elems[i].Error
+		case results[i] == nil:
+			batchErr[blockNumber] = ethereum.NotFound
+		default:
+			blockTimes[blockNumber] = uint64(results[i].Timestamp)
+			pool.headers.add(blockNumber, uint64(results[i].Timestamp))
+		}
+	}
+	if len(batchErr) > 0 {
+		return blockTimes, batchErr
+	}
+	return blockTimes, nil
+}
+
+// GetTransactionReceipts return the receipts of the given transactions, fetched in batches.
+// Transactions whose receipt could not be fetched, or that are not mined, are reported in a BatchError
+func (pool *ClientPool) GetTransactionReceipts(
+	ctx context.Context,
+	txHashes []common.Hash,
+) (_ map[common.Hash]*types.Receipt, err error) {
+	ctx, end := pool.startSpan(ctx, "GetTransactionReceipts", attrBatchSize.Int(len(txHashes)))
+	defer func() { end(err) }()
+	results := make([]*types.Receipt, len(txHashes))
+	elems := make([]rpc.BatchElem, len(txHashes))
+	for i, txHash := range txHashes {
+		elems[i] = rpc.BatchElem{
+			Method: "eth_getTransactionReceipt",
+			Args:   []interface{}{txHash},
+			Result: &results[i],
+		}
+	}
+	if err := pool.BatchCall(ctx, elems); err != nil {
+		return nil, err
+	}
+	receipts := make(map[common.Hash]*types.Receipt, len(txHashes))
+	batchErr := BatchError[common.Hash]{}
+	for i, txHash := range txHashes {
+		switch {
+		case elems[i].Error != nil:
+			batchErr[txHash] = elems[i].Error
+		case results[i] == nil:
+			batchErr[txHash] = ethereum.NotFound
+		default:
+			receipts[txHash] = results[i]
+		}
+	}
+	if len(batchErr) > 0 {
+		return receipts, batchErr
+	}
+	return receipts, nil
+}
@@ -0,0 +1,140 @@
+package client_pool_test
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/duongtuttbn/toolkit/client_pool"
+	"github.com/duongtuttbn/toolkit/client_pool/rpctest"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+func TestBlockTimesShrinkBatches(t *testing.T) {
+	f := newFixture(t, 1000, 1)
+	server := f.servers[0]
+	server.SetMaxBatchSize(10)
+	pool := f.pool(t, client_pool.Config{})
+
+	blocks := make([]uint64, 50)
+	for i := range blocks {
+		blocks[i] = uint64(i)
+	}
+	blockTimes, err := pool.BlockTimes(f.ctx, append(blocks, 2000))
+	var batchErr client_pool.BatchError[uint64]
+	if !errors.As(err, &batchErr) || len(batchErr) != 1 || !errors.Is(batchErr[2000], ethereum.NotFound) {
+		t.Fatalf("got error %v, want block 2000 reported as not found", err)
+	}
+	for _, block := range blocks {
+		if want := rpctest.GenesisTime + block*rpctest.BlockTime; blockTimes[block] != want {
+			t.Fatalf("got time %d for block %d, want %d", blockTimes[block], block, want)
+		}
+	}
+	// the rejected batches are not answered, each block is requested once in batches of at most 10 calls
+	if requests := server.Requests("eth_getBlockByNumber"); requests != 51 {
+		t.Fatalf("server got %d eth_getBlockByNumber, want 51", requests)
+	}
+
+	if _, err := pool.BlockTimes(f.ctx, blocks); err != nil {
+		t.Fatal(err)
+	}
+	if requests := server.Requests("eth_getBlockByNumber"); requests != 51 {
+		t.Fatalf("server got %d eth_getBlockByNumber, want the block times served from the cache", requests)
+	}
+}
+
+func TestGetTransactionReceiptsRetryFailedItems(t *testing.T) {
+	f := newFixture(t, 100, 2)
+	mined := []common.Hash{common.HexToHash("0xa"), common.HexToHash("0xb")}
+	for i, txHash := range mined {
+		f.chain.AddReceipt(&types.Receipt{
+			TxHash:      txHash,
+			Status:      types.ReceiptStatusSuccessful,
+			BlockNumber: big.NewInt(int64(10 + i)),
+			Logs:        []*types.Log{},
+		})
+	}
+	unknown := common.HexToHash("0xc")
+	f.servers[0].Fail("eth_getTransactionReceipt", 1, &rpctest.Error{Code: -32000, Message: "connection reset by peer"})
+	pool := f.pool(t, client_pool.Config{})
+
+	receipts, err := pool.GetTransactionReceipts(f.ctx, append(mined, unknown))
+	var batchErr client_pool.BatchError[common.Hash]
+	if !errors.As(err, &batchErr) || len(batchErr) != 1 || !errors.Is(batchErr[unknown], ethereum.NotFound) {
+		t.Fatalf("got error %v, want the unknown transaction reported as not found", err)
+	}
+	for i, txHash := range mined {
+		if receipt := receipts[txHash]; receipt == nil || receipt.BlockNumber.Uint64() != uint64(10+i) {
+			t.Fatalf("got receipt %+v of %s, want the one of block %d", receipt, txHash, 10+i)
+		}
+	}
+	// only the call that failed on the faulty endpoint is sent again, to the healthy one
+	requests := f.servers[0].Requests("eth_getTransactionReceipt") + f.servers[1].Requests("eth_getTransactionReceipt")
+	if requests != 4 {
+		t.Fatalf("servers got %d eth_getTransactionReceipt, want the 3 calls and 1 retry", requests)
+	}
+}
+
+func TestBatchCallReturnsNonRetryableErrors(t *testing.T) {
+	f := newFixture(t, 100, 1)
+	f.servers[0].SetMaxBatchSize(-1)
+	pool := f.pool(t, client_pool.Config{})
+
+	elems := make([]rpc.BatchElem, 4)
+	for i := range elems {
+		elems[i] = rpc.BatchElem{Method: "eth_blockNumber", Result: new(string)}
+	}
+	start := time.Now()
+	err := pool.BatchCall(f.ctx, elems)
+	if err == nil || f.ctx.Err() != nil {
+		t.Fatalf("got error %v, want the batch rejection before the test context is done", err)
+	}
+	if class := pool.Classify(err); class != client_pool.ClassBatchTooLarge {
+		t.Fatalf("got error class %s, want batch_too_large", class)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("returned after %v, want the rejection of a single call batch returned at once", elapsed)
+	}
+}
+
+func TestBatchErrorMessage(t *testing.T) {
+	batchErr := client_pool.BatchError[string]{}
+	for _, key := range []string{"d", "b", "a", "c", "e"} {
+		batchErr[key] = errors.New("execution reverted " + key)
+	}
+	for i := 0; i < 20; i++ {
+		if got := batchErr.Error(); got != "5 batch items failed, a: execution reverted a" {
+			t.Fatalf("got message %q, want the first key in order", got)
+		}
+	}
+}
+
+func TestBatchCallTakesATokenPerCall(t *testing.T) {
+	f := newFixture(t, 1000, 1)
+	server := f.servers[0]
+	pool := newPool(t, client_pool.Config{Endpoints: []client_pool.EndpointConfig{{
+		URL:       server.URL,
+		Label:     server.URL,
+		RateLimit: client_pool.RateLimitConfig{RequestsPerSecond: 20, Burst: 5},
+	}}})
+
+	// eth_chainId left 4 tokens, the 10 calls wait for 6 more at 20 per second
+	blocks := make([]uint64, 10)
+	for i := range blocks {
+		blocks[i] = uint64(i)
+	}
+	start := time.Now()
+	if _, err := pool.BlockTimes(f.ctx, blocks); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Fatalf("batch of 10 calls took %v, want it to wait for a token per call", elapsed)
+	}
+	if requests := server.Requests("eth_getBlockByNumber"); requests != 10 {
+		t.Fatalf("server got %d eth_getBlockByNumber, want the 10 calls in one batch", requests)
+	}
+}
@@ -0,0 +1,59 @@
+package client_pool
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// ErrFutureTimestamp is returned by BlockAtTimestamp when no block has been mined at or after the timestamp yet
+var ErrFutureTimestamp = errors.New("no block at or after the timestamp yet")
+
+// BlockAtTimestamp return the first block whose timestamp is at or after unixTime.
+// The search alternate interpolation on the average block time, which lands close to the block on chains
+// with a steady block time, and bisection, which bound the number of headers fetched on the others.
+// Every header fetched goes through the pool header cache, so searches around the same dates are cheap
+func (pool *ClientPool) BlockAtTimestamp(ctx context.Context, unixTime uint64) (_ uint64, err error) {
+	ctx, end := pool.startSpan(ctx, "BlockAtTimestamp", attrTimestamp.Int64(int64(unixTime)))
+	defer func() { end(err) }()
+	high, err := pool.GetLatestBlockContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	highTime, err := pool.BlockTimeContext(ctx, high)
+	if err != nil {
+		return 0, err
+	}
+	if unixTime > highTime {
+		return 0, ErrFutureTimestamp
+	}
+	low := uint64(0)
+	lowTime, err := pool.BlockTimeContext(ctx, low)
+	if err != nil {
+		return 0, err
+	}
+	if unixTime <= lowTime {
+		return low, nil
+	}
+
+	// the block is in (low, high]: lowTime < unixTime <= highTime
+	for step := 0; high-low > 1; step++ {
+		var guess uint64
+		if step%2 == 0 && highTime > lowTime {
+			guess = low + (unixTime-lowTime)*(high-low)/(highTime-lowTime)
+		} else {
+			guess = low + (high-low)/2
+		}
+		guess = min(max(guess, low+1), high-1)
+		guessTime, err := pool.BlockTimeContext(ctx, guess)
+		if err != nil {
+			return 0, err
+		}
+		if guessTime < unixTime {
+			low, lowTime = guess, guessTime
+		} else {
+			high, highTime = guess, guessTime
+		}
+	}
+	return high, nil
+}
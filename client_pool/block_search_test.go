@@ -0,0 +1,52 @@
+package client_pool_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/duongtuttbn/toolkit/client_pool"
+	"github.com/duongtuttbn/toolkit/client_pool/rpctest"
+)
+
+func TestBlockAtTimestamp(t *testing.T) {
+	f := newFixture(t, 1000, 1)
+	server := f.servers[0]
+	pool := f.pool(t, client_pool.Config{})
+	blockTime := func(block uint64) uint64 {
+		return rpctest.GenesisTime + block*rpctest.BlockTime
+	}
+
+	for _, test := range []struct {
+		name      string
+		timestamp uint64
+		want      uint64
+	}{
+		{"before genesis", rpctest.GenesisTime - 100, 0},
+		{"at genesis", blockTime(0), 0},
+		{"just after genesis", blockTime(0) + 1, 1},
+		{"at a block", blockTime(500), 500},
+		{"between blocks", blockTime(500) + 1, 501},
+		{"at the head", blockTime(1000), 1000},
+	} {
+		block, err := pool.BlockAtTimestamp(f.ctx, test.timestamp)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if block != test.want {
+			t.Fatalf("%s: got block %d, want %d", test.name, block, test.want)
+		}
+	}
+	if _, err := pool.BlockAtTimestamp(f.ctx, blockTime(1000)+1); !errors.Is(err, client_pool.ErrFutureTimestamp) {
+		t.Fatalf("got error %v, want ErrFutureTimestamp after the head", err)
+	}
+
+	// the headers fetched by a search are cached, searching again only fetch the head number
+	fetched := server.Requests("eth_getBlockByNumber")
+	block, err := pool.BlockAtTimestamp(f.ctx, blockTime(500)+1)
+	if err != nil || block != 501 {
+		t.Fatalf("got block %d (error %v), want 501", block, err)
+	}
+	if requests := server.Requests("eth_getBlockByNumber") - fetched; requests != 0 {
+		t.Fatalf("server got %d eth_getBlockByNumber, want the headers served from the cache", requests)
+	}
+}
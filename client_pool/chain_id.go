@@ -0,0 +1,151 @@
+package client_pool
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/duongtuttbn/toolkit/log"
+	"github.com/pkg/errors"
+)
+
+// chain id checks of Config.ChainIDCheck
+const (
+	// ChainIDReject fail the pool construction when an endpoint serve another chain
+	ChainIDReject = "reject"
+	// ChainIDQuarantine take the endpoints serving another chain out of rotation
+	ChainIDQuarantine = "quarantine"
+	// ChainIDSkip never ask the endpoints their chain id
+	ChainIDSkip = "skip"
+)
+
+// chainIDTimeout bound the eth_chainId requests sent while the pool is constructed
+const chainIDTimeout = 5 * time.Second
+
+// ChainIDError is returned when endpoints serve another chain than the pool
+type ChainIDError struct {
+	Expected uint64
+	// Endpoints map the labels of the mismatched endpoints to the chain they serve
+	Endpoints map[string]uint64
+}
+
+func (e *ChainIDError) Error() string {
+	return fmt.Sprintf("endpoints serve another chain than %d: %v", e.Expected, e.Endpoints)
+}
+
+// ChainID return the chain served by the pool, zero when it is not known yet because
+// Config.ChainID is not set and no endpoint answered eth_chainId
+func (pool *ClientPool) ChainID() uint64 {
+	return pool.chainID.Load()
+}
+
+// checkChainIDs ask every endpoint its chain id. The pool serve Config.ChainID, or else the chain served by
+// most endpoints. Mismatched endpoints are rejected or quarantined, depending on Config.ChainIDCheck.
+// Endpoints that do not answer are benched and checked by their first probe
+func (pool *ClientPool) checkChainIDs() error {
+	pool.chainID.Store(pool.config.ChainID)
+	check := pool.config.ChainIDCheck
+	switch check {
+	case ChainIDSkip:
+		return nil
+	case "", ChainIDReject, ChainIDQuarantine:
+	default:
+		return errors.Errorf("unknown chain id check: %s", check)
+	}
+	for _, client := range pool.clients {
+		client.verifyChainID = func(ctx context.Context) error {
+			return pool.verifyChainID(ctx, client)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), chainIDTimeout)
+	defer cancel()
+	chainIDs := make([]uint64, len(pool.clients))
+	var wg sync.WaitGroup
+	for i, client := range pool.clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			chainID, err := call(withMethod(ctx, "eth_chainId"), client, client.Client.ChainID)
+			if err != nil {
+				log.FromContext(ctx).Warnf("get chain id of endpoint %s error, check it on its first probe: %v", client.Label(), err)
+				client.MarkError(err)
+				return
+			}
+			chainIDs[i] = chainID.Uint64()
+		}()
+	}
+	wg.Wait()
+
+	expected := pool.config.ChainID
+	if expected == 0 {
+		expected = mostCommon(chainIDs)
+		pool.chainID.Store(expected)
+	}
+	mismatches := make(map[string]uint64)
+	for i, client := range pool.clients {
+		switch chainIDs[i] {
+		case 0:
+		case expected:
+			client.setChainID(expected)
+		default:
+			mismatches[client.Label()] = chainIDs[i]
+		}
+	}
+	if len(mismatches) == 0 {
+		return nil
+	}
+	err := &ChainIDError{Expected: expected, Endpoints: mismatches}
+	if check != ChainIDQuarantine {
+		return err
+	}
+	if len(mismatches) == len(pool.clients) {
+		return err
+	}
+	for i, client := range pool.clients {
+		if chainIDs[i] != 0 && chainIDs[i] != expected {
+			client.quarantine(chainIDs[i], err)
+		}
+	}
+	log.FromContext(ctx).Errorf("quarantine endpoints: %v", err)
+	return nil
+}
+
+// verifyChainID ask an endpoint that was not checked yet its chain id, and quarantine it on mismatch.
+// The first endpoint to answer decide the chain of a pool that does not know it yet
+func (pool *ClientPool) verifyChainID(ctx context.Context, client *Client) error {
+	if client.verifiedChainID() != 0 {
+		return nil
+	}
+	id, err := call(withMethod(ctx, "eth_chainId"), client, client.Client.ChainID)
+	if err != nil {
+		return errors.Wrap(err, "unable to get chain id")
+	}
+	chainID := id.Uint64()
+	pool.chainID.CompareAndSwap(0, chainID)
+	if expected := pool.chainID.Load(); chainID != expected {
+		err := &ChainIDError{Expected: expected, Endpoints: map[string]uint64{client.Label(): chainID}}
+		client.quarantine(chainID, err)
+		log.FromContext(ctx).Errorf("quarantine endpoint %s: %v", client.Label(), err)
+		return err
+	}
+	client.setChainID(chainID)
+	return nil
+}
+
+// mostCommon return the non-zero chain id found the most, the first one found on a tie
+func mostCommon(chainIDs []uint64) uint64 {
+	counts := make(map[uint64]int)
+	var best uint64
+	for _, chainID := range chainIDs {
+		if chainID == 0 {
+			continue
+		}
+		counts[chainID]++
+		if counts[chainID] > counts[best] {
+			best = chainID
+		}
+	}
+	return best
+}
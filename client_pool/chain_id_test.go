@@ -0,0 +1,68 @@
+package client_pool_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/duongtuttbn/toolkit/client_pool"
+	"github.com/duongtuttbn/toolkit/client_pool/rpctest"
+)
+
+func TestChainIDMismatch(t *testing.T) {
+	chain, bsc := rpctest.NewChain(100), rpctest.NewChain(100)
+	bsc.SetChainID(56)
+	first, second, wrong := newServer(t, chain), newServer(t, chain), newServer(t, bsc)
+	cfg := client_pool.Config{}
+	for _, server := range []*rpctest.Server{first, wrong, second} {
+		cfg.Endpoints = append(cfg.Endpoints, client_pool.EndpointConfig{URL: server.URL, Label: server.URL})
+	}
+
+	_, err := client_pool.NewBasicClientPool(cfg)
+	var chainIDErr *client_pool.ChainIDError
+	if !errors.As(err, &chainIDErr) {
+		t.Fatalf("got error %v, want a chain id error", err)
+	}
+	if chainIDErr.Expected != rpctest.DefaultChainID || chainIDErr.Endpoints[wrong.URL] != 56 {
+		t.Fatalf("unexpected chain id error: %v", chainIDErr)
+	}
+
+	cfg.ChainIDCheck = client_pool.ChainIDQuarantine
+	pool, err := client_pool.NewBasicClientPool(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pool.ChainID() != rpctest.DefaultChainID {
+		t.Fatalf("pool chain id is %d, want %d", pool.ChainID(), rpctest.DefaultChainID)
+	}
+	if !pool.GetAllClients()[1].Quarantined() {
+		t.Fatal("endpoint of another chain is not quarantined")
+	}
+	ctx := testContext(t)
+	for i := 0; i < 4; i++ {
+		if _, err := pool.GetLatestBlockContext(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if requests := wrong.Requests("eth_blockNumber"); requests != 0 {
+		t.Fatalf("quarantined endpoint got %d requests", requests)
+	}
+}
+
+func TestChainIDMismatchOfSharedLabels(t *testing.T) {
+	chain, bsc, polygon := rpctest.NewChain(100), rpctest.NewChain(100), rpctest.NewChain(100)
+	bsc.SetChainID(56)
+	polygon.SetChainID(137)
+	cfg := client_pool.Config{}
+	for _, server := range []*rpctest.Server{newServer(t, chain), newServer(t, bsc), newServer(t, chain), newServer(t, polygon)} {
+		cfg.Endpoints = append(cfg.Endpoints, client_pool.EndpointConfig{URL: server.URL, Label: "provider"})
+	}
+
+	_, err := client_pool.NewBasicClientPool(cfg)
+	var chainIDErr *client_pool.ChainIDError
+	if !errors.As(err, &chainIDErr) {
+		t.Fatalf("got error %v, want a chain id error", err)
+	}
+	if len(chainIDErr.Endpoints) != 2 || chainIDErr.Endpoints["provider#1"] != 56 || chainIDErr.Endpoints["provider#3"] != 137 {
+		t.Fatalf("got mismatched endpoints %v, want both of them", chainIDErr.Endpoints)
+	}
+}
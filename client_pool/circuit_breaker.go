@@ -0,0 +1,31 @@
+package client_pool
+
+import "time"
+
+// CircuitState is the circuit breaker state of a Client
+type CircuitState int
+
+const (
+	// CircuitClosed means the client is healthy and serve requests
+	CircuitClosed CircuitState = iota
+	// CircuitOpen means the client is benched until its backoff is over
+	CircuitOpen
+	// CircuitHalfOpen means the bench is over and a single probe decide whether the client is restored
+	CircuitHalfOpen
+)
+
+// probeTimeout is the maximum time a half-open probe may take
+const probeTimeout = 10 * time.Second
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
@@ -5,11 +5,19 @@ import (
 	"github.com/pkg/errors"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/duongtuttbn/toolkit/log"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/go-resty/resty/v2"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
 )
 
 type Client struct {
@@ -18,46 +26,116 @@ type Client struct {
 	availableAt time.Time
 	mu          sync.Mutex
 	rpcClient   *rpc.Client
+	httpClient  *http.Client
 	endpoint    string
+	redacted    string
+	label       string
+	headers     http.Header
+	proxy       string
+	limiter     *rate.Limiter
+	backoff     BackoffPolicy
+	failures    int
+	successes   int
+	retryAfter  *retryAfterRecorder
+	state       CircuitState
+	// lagging is set by the HealthChecker when the endpoint is too far behind the best head
+	lagging bool
+	// onStateChange is called when the client may have become available again
+	onStateChange func()
+	weight        int
+	inFlight      atomic.Int64
+	// latency is the EWMA of the request durations
+	latency time.Duration
+	// index is the position of the client in its pool
+	index int
+	// logWindow is the number of blocks requested in one eth_getLogs, learned from the endpoint errors
+	logWindow uint64
+	// websocket is set when the client is dialed over ws:// or wss:// and support subscriptions
+	websocket bool
+	// batchSize is the largest JSON-RPC batch sent to the endpoint, learned from its errors
+	batchSize int
+	// classify is the error classification of the pool, Classify is used when it is nil
+	classify func(error) ErrorClass
+	metrics  clientMetrics
+	// tracer start the spans of the requests, they are not traced when it is nil
+	tracer trace.Tracer
+	// chainID is the chain served by the endpoint, zero until it is checked
+	chainID uint64
+	// quarantined is set when the endpoint serve another chain than its pool, it is never used again
+	quarantined bool
+	// verifyChainID check the chain of the endpoint on its next probe when it is not known yet
+	verifyChainID func(ctx context.Context) error
 }
 
 // NewClient initialize new http or universal client based on the given parameters
 func NewClient(endpoint, proxyURL string) (*Client, error) {
-	u, err := url.Parse(endpoint)
+	return NewEndpointClient(EndpointConfig{URL: endpoint, Proxy: proxyURL})
+}
+
+// NewEndpointClient initialize new http or universal client based on the endpoint config
+func NewEndpointClient(cfg EndpointConfig) (*Client, error) {
+	u, err := url.Parse(cfg.URL)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to parse endpoint to url")
 	}
+	var client *Client
 	switch u.Scheme {
 	case "http", "https":
-		return NewHTTPClient(endpoint, proxyURL)
+		client, err = newHTTPClient(cfg)
 	default:
-		return NewUniversalClient(endpoint)
+		client, err = newUniversalClient(cfg)
 	}
+	if err != nil {
+		return nil, err
+	}
+	client.weight = cfg.Weight
+	client.limiter = cfg.RateLimit.limiter()
+	client.logWindow = cfg.LogWindow
+	client.batchSize = cfg.MaxBatchSize
+	return client, nil
 }
 
 func NewUniversalClient(endpoint string) (*Client, error) {
-	client, err := ethclient.Dial(endpoint)
+	return newUniversalClient(EndpointConfig{URL: endpoint})
+}
+
+func newUniversalClient(cfg EndpointConfig) (*Client, error) {
+	headers := cfg.httpHeaders()
+	client, err := rpc.DialOptions(context.Background(), cfg.URL, rpc.WithHeaders(headers))
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to dial endpoint with eth client")
 	}
 	return &Client{
-		Client:      client,
+		Client:      ethclient.NewClient(client),
 		lastErr:     nil,
 		availableAt: time.Now(),
-		endpoint:    endpoint,
+		rpcClient:   client,
+		endpoint:    cfg.URL,
+		redacted:    redactURL(cfg.URL),
+		label:       cfg.Label,
+		headers:     headers,
+		websocket:   strings.HasPrefix(cfg.URL, "ws://") || strings.HasPrefix(cfg.URL, "wss://"),
 	}, nil
 }
 
 func NewHTTPClient(endpoint string, proxyURL string) (*Client, error) {
-	httpClient := &http.Client{}
-	if proxyURL != "" {
-		proxyUrl, err := url.Parse(proxyURL)
+	return newHTTPClient(EndpointConfig{URL: endpoint, Proxy: proxyURL})
+}
+
+func newHTTPClient(cfg EndpointConfig) (*Client, error) {
+	// each endpoint has its own connections, so closing a client does not close those of the others
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.Proxy != "" {
+		proxyUrl, err := url.Parse(cfg.Proxy)
 		if err != nil {
 			return nil, errors.Wrap(err, "unable to parse proxyURL to url")
 		}
-		httpClient.Transport = &http.Transport{Proxy: http.ProxyURL(proxyUrl)}
+		transport = &http.Transport{Proxy: http.ProxyURL(proxyUrl)}
 	}
-	client, err := rpc.DialOptions(context.Background(), endpoint, rpc.WithHTTPClient(httpClient))
+	retryAfter := &retryAfterRecorder{}
+	httpClient := &http.Client{Transport: &retryAfterTransport{base: transport, recorder: retryAfter}}
+	headers := cfg.httpHeaders()
+	client, err := rpc.DialOptions(context.Background(), cfg.URL, rpc.WithHTTPClient(httpClient), rpc.WithHeaders(headers))
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to dial endpoint with rpc")
 	}
@@ -67,34 +145,352 @@ func NewHTTPClient(endpoint string, proxyURL string) (*Client, error) {
 		lastErr:     nil,
 		availableAt: time.Now(),
 		rpcClient:   client,
-		endpoint:    endpoint,
+		httpClient:  httpClient,
+		endpoint:    cfg.URL,
+		redacted:    redactURL(cfg.URL),
+		label:       cfg.Label,
+		headers:     headers,
+		proxy:       cfg.Proxy,
+		retryAfter:  retryAfter,
 	}, nil
 }
 
 // IsAvailable let you know that the client is available for use or not
 func (c *Client) IsAvailable() bool {
+	available, _ := c.availability()
+	return available
+}
+
+// availability let you know that the client is available for use or not, and if not, how long until
+// it may be available again. A client that used up its rate limit budget is not available.
+// A negative duration means it is waiting for a probe.
+// An open circuit whose bench is over moves to half-open and start probing the endpoint
+func (c *Client) availability() (bool, time.Duration) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if c.lastErr == nil || time.Now().After(c.availableAt) {
-		c.lastErr = nil
-		return true
+	if c.lagging || c.quarantined {
+		return false, -1
 	}
-	return false
+	switch c.state {
+	case CircuitClosed:
+		if wait := c.budgetWait(); wait > 0 {
+			return false, wait
+		}
+		return true, 0
+	case CircuitOpen:
+		if wait := time.Until(c.availableAt); wait > 0 {
+			return false, wait
+		}
+		c.state = CircuitHalfOpen
+		go c.probe()
+	}
+	return false, -1
 }
 
-// MarkError set the lassErr and availableAt to now+1minute
+// State return the current circuit breaker state of the client
+func (c *Client) State() CircuitState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// MarkError set the lastErr, open the circuit and bench the client for the duration given by
+// its backoff policy, or longer if the endpoint answered with a Retry-After header
 func (c *Client) MarkError(err error) {
+	err = c.redactError(err)
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.lastErr = err
-	// client will be available after 15 seconds
-	c.availableAt = time.Now().Add(15 * time.Second)
+	c.state = CircuitOpen
+	c.successes = 0
+	c.failures++
+	c.metrics.observeBench()
+	bench := c.backoffPolicy().Backoff(c.failures, err)
+	var httpErr rpc.HTTPError
+	if errors.As(err, &httpErr) {
+		if retryAfter := c.retryAfter.remaining(); retryAfter > bench {
+			bench = retryAfter
+		}
+	}
+	c.availableAt = time.Now().Add(bench)
+}
+
+// MarkSuccess record a successful request, the failure streak is forgotten once the backoff policy allows it
+func (c *Client) MarkSuccess() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failures == 0 {
+		return
+	}
+	c.successes++
+	if c.backoffPolicy().Reset(c.successes) {
+		c.failures = 0
+		c.successes = 0
+	}
+}
+
+// SetBackoff change the policy used to bench the client after an error
+func (c *Client) SetBackoff(policy BackoffPolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.backoff = policy
+}
+
+// probe send a single request to a half-open client, closing the circuit on success
+// and opening it again on failure
+func (c *Client) probe() {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	if c.verifyChainID != nil {
+		if err := c.verifyChainID(ctx); err != nil {
+			log.FromContext(ctx).Infof("probe endpoint %s error: %v", c.Label(), err)
+			if !c.Quarantined() {
+				c.MarkError(err)
+			}
+			c.notifyStateChange()
+			return
+		}
+	}
+	if _, err := call(withMethod(ctx, "eth_blockNumber"), c, c.BlockNumber); err != nil {
+		log.FromContext(ctx).Infof("probe endpoint %s error: %v", c.Label(), err)
+		c.MarkError(err)
+	} else {
+		c.mu.Lock()
+		c.state = CircuitClosed
+		c.lastErr = nil
+		c.mu.Unlock()
+		c.MarkSuccess()
+	}
+	c.notifyStateChange()
+}
+
+// setLagging take the client out of rotation, or put it back, regardless of its circuit state
+func (c *Client) setLagging(lagging bool) {
+	c.mu.Lock()
+	changed := c.lagging != lagging
+	c.lagging = lagging
+	c.mu.Unlock()
+	if changed && !lagging {
+		c.notifyStateChange()
+	}
+}
+
+// Quarantined let you know that the endpoint serve another chain than its pool, so it is never used
+func (c *Client) Quarantined() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.quarantined
+}
+
+// quarantine take the client out of rotation for good, chainID is the chain it serve
+func (c *Client) quarantine(chainID uint64, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.chainID = chainID
+	c.quarantined = true
+	c.lastErr = c.redactError(err)
+}
+
+func (c *Client) setChainID(chainID uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.chainID = chainID
+}
+
+// verifiedChainID return the chain served by the endpoint, zero if it was not checked yet
+func (c *Client) verifiedChainID() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.chainID
+}
+
+func (c *Client) notifyStateChange() {
+	c.mu.Lock()
+	onStateChange := c.onStateChange
+	c.mu.Unlock()
+	if onStateChange != nil {
+		onStateChange()
+	}
+}
+
+// SetWeight change the share of requests the weighted selector give to the client, default to 1
+func (c *Client) SetWeight(weight int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.weight = weight
+}
+
+// Weight return the share of requests the weighted selector give to the client
+func (c *Client) Weight() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.weight <= 0 {
+		return 1
+	}
+	return c.weight
+}
+
+// InFlight return the number of requests currently sent through the pool helpers to the client
+func (c *Client) InFlight() int64 {
+	return c.inFlight.Load()
+}
+
+// Latency return the exponentially weighted moving average of the client request durations
+func (c *Client) Latency() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.latency
+}
+
+func (c *Client) observeLatency(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.latency == 0 {
+		c.latency = d
+		return
+	}
+	c.latency = time.Duration(latencyDecay*float64(d) + (1-latencyDecay)*float64(c.latency))
+}
+
+func (c *Client) classifyError(err error) ErrorClass {
+	if c.classify == nil {
+		return Classify(err)
+	}
+	return c.classify(err)
+}
+
+func (c *Client) backoffPolicy() BackoffPolicy {
+	if c.backoff == nil {
+		return DefaultBackoff()
+	}
+	return c.backoff
+}
+
+func (c *Client) maxBatchSize() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.batchSize <= 0 {
+		return defaultMaxBatchSize
+	}
+	return c.batchSize
+}
+
+func (c *Client) setMaxBatchSize(size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.batchSize = max(size, 1)
+}
+
+// blockTime fetch the timestamp of the block from its header, without its transactions
+func (c *Client) blockTime(ctx context.Context, blockNumber uint64) (uint64, error) {
+	var header *blockTimeResult
+	if err := c.rpcClient.CallContext(ctx, &header, "eth_getBlockByNumber", hexutil.EncodeUint64(blockNumber), false); err != nil {
+		return 0, err
+	}
+	if header == nil {
+		return 0, ethereum.NotFound
+	}
+	return uint64(header.Timestamp), nil
+}
+
+// manualBlockTime fetch the timestamp of the block with a plain HTTP request, see Config.ManualBlockTime
+func (c *Client) manualBlockTime(ctx context.Context, blockNumber uint64) (uint64, error) {
+	body := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  "eth_getBlockByNumber",
+		"params": []interface{}{
+			DecimalToHex(int64(blockNumber)),
+			false,
+		},
+		"id": 0,
+	}
+	client := resty.New().SetHeaders(headerValues(c.headers))
+	if c.proxy != "" {
+		client.SetProxy(c.proxy)
+	}
+	var response struct {
+		GetBlockTimeResponse
+		Error *jsonRPCError `json:"error"`
+	}
+	res, err := client.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetBody(body).
+		SetResult(&response).
+		Post(c.endpoint)
+	if err != nil {
+		return 0, err
+	}
+	if res.IsError() {
+		if delay, ok := parseRetryAfter(res.Header().Get("Retry-After"), time.Now()); ok {
+			c.retryAfter.record(delay)
+		}
+		return 0, rpc.HTTPError{StatusCode: res.StatusCode(), Status: res.Status(), Body: res.Body()}
+	}
+	if response.Error != nil {
+		return 0, response.Error
+	}
+	// the result is null when the block does not exist
+	if response.Result.Timestamp == "" {
+		return 0, ethereum.NotFound
+	}
+	blockTime, err := HexToInt(response.Result.Timestamp)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid timestamp %q of block %d", response.Result.Timestamp, blockNumber)
+	}
+	return uint64(blockTime), nil
+}
+
+// jsonRPCError is the error object of a JSON-RPC response, classified by its code like the rpc client errors
+type jsonRPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *jsonRPCError) Error() string {
+	return e.Message
+}
+
+func (e *jsonRPCError) ErrorCode() int {
+	return e.Code
+}
+
+// Close close the connection to the endpoint. The rpc client keep the connections of an HTTP endpoint
+// open, so they are closed along with it
+func (c *Client) Close() {
+	c.Client.Close()
+	if c.httpClient != nil {
+		c.httpClient.CloseIdleConnections()
+	}
 }
 
 func (c *Client) GetRPCClient() *rpc.Client {
 	return c.rpcClient
 }
 
+// EndpointURL return the endpoint URL with its embedded credentials redacted
 func (c *Client) EndpointURL() string {
-	return c.endpoint
+	return c.redacted
+}
+
+// LogWindow return the number of blocks currently requested from the client in one eth_getLogs,
+// zero until the pool used it for logs
+func (c *Client) LogWindow() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.logWindow
+}
+
+// redactError hide the credentials of the endpoint URL embedded in the message of err
+func (c *Client) redactError(err error) error {
+	return redactError(err, c.endpoint)
+}
+
+// Label return the name of the client used in logs, default to the redacted endpoint URL.
+// It is unique in a pool, the clients sharing a label get their index appended, e.g. "infura#1"
+func (c *Client) Label() string {
+	if c.label != "" {
+		return c.label
+	}
+	return c.redacted
 }
@@ -1,30 +1,39 @@
 package client_pool
 
 import (
+	"bytes"
 	"context"
 	"fmt"
-	"github.com/chenzhijie/go-web3"
 	"github.com/duongtuttbn/toolkit/log"
 	"github.com/duongtuttbn/toolkit/model"
-	"github.com/duongtuttbn/toolkit/utils"
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/go-resty/resty/v2"
 	"github.com/pkg/errors"
-	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 	"math/big"
-	"strings"
+	"net/http"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type (
 	ClientPool struct {
-		clients []*Client
-		counter int
-		mu      sync.Mutex
-		config  Config
+		clients  []*Client
+		selector Selector
+		mu       sync.Mutex
+		config   Config
+		// wakeup is closed and replaced every time a client may have become available
+		wakeup         chan struct{}
+		hedgeMu        sync.Mutex
+		hedgeLatencies map[string]*latencyWindow
+		headers        *headerCache
+		tracer         trace.Tracer
+		// chainID is the chain served by the pool, zero until it is known
+		chainID atomic.Uint64
+		nonces  *nonceManager
 	}
 
 	GetBlockTimeResponse struct {
@@ -40,90 +49,187 @@ const (
 )
 
 func NewBasicClientPool(cfg Config) (*ClientPool, error) {
-	rpcUrls := strings.Split(cfg.RpcUrls, ",")
-	clients := make([]*Client, len(rpcUrls))
-	for i, rpcUrl := range rpcUrls {
-		var err error
-		clients[i], err = NewClient(rpcUrl, "")
+	endpoints := cfg.endpointConfigs()
+	if len(endpoints) == 0 {
+		return nil, ErrEmptyPool
+	}
+	selector, err := newSelector(cfg)
+	if err != nil {
+		return nil, err
+	}
+	pool := &ClientPool{
+		clients:  make([]*Client, len(endpoints)),
+		selector: selector,
+		config:   cfg,
+		wakeup:   make(chan struct{}),
+		headers:  newHeaderCache(cfg.HeaderCache),
+		tracer:   newTracer(cfg.TracerProvider),
+		nonces:   newNonceManager(),
+	}
+	for i, endpoint := range endpoints {
+		client, err := NewEndpointClient(endpoint)
 		if err != nil {
-			return nil, errors.Wrap(err, "unable to init new client")
+			pool.Close()
+			return nil, errors.Wrapf(redactError(err, endpoint.URL), "unable to init new client %s", redactURL(endpoint.URL))
+		}
+		client.SetBackoff(cfg.Backoff)
+		client.onStateChange = pool.broadcast
+		client.classify = pool.Classify
+		client.tracer = pool.tracer
+		client.index = i
+		pool.clients[i] = client
+	}
+	uniqueLabels(pool.clients)
+	if err := pool.checkChainIDs(); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return pool, nil
+}
+
+// Close close the clients of the pool, it must not be used afterwards
+func (pool *ClientPool) Close() {
+	for _, client := range pool.clients {
+		// the clients after an endpoint that failed to init are not built
+		if client != nil {
+			client.Close()
+		}
+	}
+}
+
+// uniqueLabels append the client index to the labels shared by several clients, like the redacted URLs
+// of two API keys of the same provider, so the heads, errors and metrics keyed by label stay apart
+func uniqueLabels(clients []*Client) {
+	counts := make(map[string]int, len(clients))
+	for _, client := range clients {
+		counts[client.Label()]++
+	}
+	for _, client := range clients {
+		if label := client.Label(); counts[label] > 1 {
+			client.label = fmt.Sprintf("%s#%d", label, client.index)
 		}
 	}
-	return &ClientPool{
-		clients: clients,
-		config:  cfg,
-	}, nil
 }
 
 // GetClient return a client that available for use,
 // blocked if there is no client available
 func (pool *ClientPool) GetClient() *Client {
-	pool.mu.Lock()
-	defer pool.mu.Unlock()
+	client, _ := pool.GetClientContext(context.Background())
+	return client
+}
+
+// GetClientContext return a client that available for use. If every client is down it waits,
+// without holding the pool lock, until one of them is available again or ctx is done
+func (pool *ClientPool) GetClientContext(ctx context.Context) (*Client, error) {
+	if len(pool.clients) == 0 {
+		return nil, ErrEmptyPool
+	}
 	for {
-		for i := 0; i < len(pool.clients); i++ {
-			client := pool.clients[pool.counter]
-			pool.counter = (pool.counter + 1) % len(pool.clients)
-			if client.IsAvailable() {
-				log.Debugf("Use client: %s", client.endpoint)
-				return client
-			}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		clients, wait, wakeup := pool.nextClients(1)
+		if clients != nil {
+			log.FromContext(ctx).Debugf("Use client: %s", clients[0].Label())
+			return clients[0], nil
+		}
+		log.FromContext(ctx).Infof("no client available, wait for one of them to recover or regain rate limit budget")
+		if err := waitForChange(ctx, wait, wakeup); err != nil {
+			return nil, err
 		}
-		logrus.Infof("all clients are down, sleep for 15 seconds")
-		time.Sleep(15 * time.Second)
 	}
 }
 
 // GetClients return all available clients
 func (pool *ClientPool) GetClients(numClients int) ([]*Client, error) {
+	return pool.GetClientsContext(context.Background(), numClients)
+}
+
+// GetClientsContext return numClients distinct available clients, waiting without holding
+// the pool lock until enough of them are available or ctx is done
+func (pool *ClientPool) GetClientsContext(ctx context.Context, numClients int) ([]*Client, error) {
 	if numClients > len(pool.clients) {
 		return nil, errors.New(fmt.Sprintf("numClients must be less than client pool size: %d", len(pool.clients)))
 	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		clients, wait, wakeup := pool.nextClients(numClients)
+		if clients != nil {
+			return clients, nil
+		}
+		log.FromContext(ctx).Infof("Request %d clients but not enough available. Wait for clients to recover", numClients)
+		if err := waitForChange(ctx, wait, wakeup); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// nextClients ask the selector for numClients distinct available clients, other than the excluded ones.
+// If there are not enough, it returns how long until the next client is available again
+// and the channel closed on the next state change
+func (pool *ClientPool) nextClients(numClients int, exclude ...*Client) ([]*Client, time.Duration, <-chan struct{}) {
 	pool.mu.Lock()
 	defer pool.mu.Unlock()
-
-	for {
-		availableClients := pool.countAvailableClients()
-		if availableClients < numClients {
-			logrus.Infof("Request %d clients but only %d available. Sleep for 1 minute", numClients, availableClients)
-			time.Sleep(time.Minute)
+	candidates := make([]*Client, 0, len(pool.clients))
+	wait := time.Duration(-1)
+	for _, client := range pool.clients {
+		if slices.Contains(exclude, client) {
 			continue
-		} else {
-			break
+		}
+		available, availableIn := client.availability()
+		if available {
+			candidates = append(candidates, client)
+		} else if availableIn >= 0 && (wait < 0 || availableIn < wait) {
+			wait = availableIn
 		}
 	}
-
+	if len(candidates) < numClients {
+		return nil, wait, pool.wakeupChannel()
+	}
 	clients := make([]*Client, 0, numClients)
 	for len(clients) < numClients {
-		client := pool.clients[pool.counter]
-		pool.counter = (pool.counter + 1) % len(pool.clients)
-		if client.IsAvailable() {
-			clients = append(clients, client)
-		}
+		client := pool.selector.Select(candidates)
+		clients = append(clients, client)
+		candidates = removeClient(candidates, client)
 	}
-	return clients, nil
+	return clients, 0, nil
 }
 
-// GetAllClients return all clients regardless their availability
-func (pool *ClientPool) GetAllClients() []*Client {
-	return pool.clients
+// wakeupChannel return the channel closed on the next client state change, pool.mu must be held
+func (pool *ClientPool) wakeupChannel() <-chan struct{} {
+	if pool.wakeup == nil {
+		pool.wakeup = make(chan struct{})
+	}
+	return pool.wakeup
 }
 
-func (pool *ClientPool) countAvailableClients() int {
-	counter := 0
-	for _, client := range pool.clients {
-		if client.IsAvailable() {
-			counter++
-		}
+// broadcast wake up every goroutine waiting for a client
+func (pool *ClientPool) broadcast() {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if pool.wakeup != nil {
+		close(pool.wakeup)
 	}
-	return counter
+	pool.wakeup = make(chan struct{})
+}
+
+// GetAllClients return all clients regardless their availability
+func (pool *ClientPool) GetAllClients() []*Client {
+	return pool.clients
 }
 
 // RunOp execute a given callback for all clients in the pool
 func (pool *ClientPool) RunOp(ctx context.Context, op func(client *Client) error) {
 	for ctx.Err() == nil {
-		client := pool.GetClient()
-		err := op(client)
+		client, err := pool.GetClientContext(ctx)
+		if err != nil {
+			return
+		}
+		_, err = call(ctx, client, func(context.Context) (struct{}, error) {
+			return struct{}{}, op(client)
+		})
 		if err == nil {
 			return
 		}
@@ -132,15 +238,45 @@ func (pool *ClientPool) RunOp(ctx context.Context, op func(client *Client) error
 
 // GetLatestBlock return latest block number
 func (pool *ClientPool) GetLatestBlock() uint64 {
+	maxBlock, _ := pool.GetLatestBlockContext(context.Background())
+	return maxBlock
+}
+
+// GetLatestBlockContext return latest block number, retrying on other clients until ctx is done.
+// Errors that are not retryable are returned right away
+func (pool *ClientPool) GetLatestBlockContext(ctx context.Context) (block uint64, err error) {
+	ctx, end := pool.startSpan(ctx, "GetLatestBlock")
+	defer func() {
+		if err == nil {
+			pool.headers.observeHead(block)
+		}
+		end(err)
+	}()
+	ctx = withMethod(ctx, "eth_blockNumber")
+	if pool.config.Hedge.Enabled {
+		return hedged(ctx, pool, "eth_blockNumber", pool.retryable, func(ctx context.Context, client *Client) (uint64, error) {
+			return client.BlockNumber(ctx)
+		})
+	}
 	for {
-		client := pool.GetClient()
-		maxBlock, err := client.BlockNumber(context.Background())
+		client, err := pool.GetClientContext(ctx)
+		if err != nil {
+			return 0, err
+		}
+		maxBlock, err := call(ctx, client, client.BlockNumber)
 		if err != nil {
+			if ctx.Err() != nil {
+				return 0, ctx.Err()
+			}
+			if !pool.retryable(err) {
+				return 0, err
+			}
 			client.MarkError(err)
-			logrus.Errorf("get max block error: %v", err)
+			log.FromContext(ctx).Errorf("get max block error: %v", err)
 			continue
 		}
-		return maxBlock
+		client.MarkSuccess()
+		return maxBlock, nil
 	}
 }
 
@@ -148,71 +284,37 @@ func (pool *ClientPool) GetLogs(filterQuery ethereum.FilterQuery, fromBlock, toB
 	[]types.Log,
 	error,
 ) {
-	if numProof <= 1 {
-		return pool.getLogs(filterQuery, fromBlock, toBlock)
-	}
-
-	var wg sync.WaitGroup
-	wg.Add(numProof)
-	results := make([][]types.Log, numProof)
-	resultsLocker := sync.Mutex{}
-	availableClients, err := pool.GetClients(numProof)
-	if err != nil {
-		return nil, errors.Wrap(err, "Unable to get clients")
-	}
+	return pool.GetLogsContext(context.Background(), filterQuery, fromBlock, toBlock, numProof)
+}
 
-	for i := 0; i < numProof; i++ {
-		go func(index int) {
-			defer wg.Done()
-			logs, getLogsError := pool.getLogs(filterQuery, fromBlock, toBlock, availableClients[index])
-			if getLogsError != nil {
-				err = errors.Wrapf(getLogsError, "getLogs {%d} error", index)
-			}
-			resultsLocker.Lock()
-			results[index] = logs
-			resultsLocker.Unlock()
-		}(i)
+// GetLogsContext is GetLogs that stops retrying once ctx is done
+func (pool *ClientPool) GetLogsContext(
+	ctx context.Context,
+	filterQuery ethereum.FilterQuery,
+	fromBlock, toBlock uint64,
+	numProof int,
+) (logs []types.Log, err error) {
+	ctx, end := pool.startSpan(ctx, "GetLogs", blockAttrs(fromBlock, toBlock)...)
+	defer func() { end(err) }()
+	if numProof <= 1 {
+		return pool.getLogs(ctx, filterQuery, fromBlock, toBlock)
 	}
-
-	wg.Wait()
+	result, err := pool.GetLogsQuorum(ctx, filterQuery, fromBlock, toBlock, numProof, numProof)
 	if err != nil {
 		return nil, err
 	}
-
-	consistent := true
-	for i := 0; i < numProof; i++ {
-		if i < numProof-1 {
-			consistent = pool.compareListsLogs(results[i], results[i+1])
-			if !consistent {
-				break
-			}
-		}
-	}
-	if consistent {
-		return results[0], nil
-	} else {
-		for i := 0; i < numProof; i++ {
-			logrus.Infof(
-				"\t[Consistency error trace] Block range: [%d, %d]: Client %s returned %d logs",
-				fromBlock,
-				toBlock,
-				availableClients[i].endpoint,
-				len(results[i]),
-			)
-		}
-		return nil, errors.New("Consistency error")
-	}
+	return result.Logs, nil
 }
 
 func (pool *ClientPool) compareListsLogs(logs1 []types.Log, logs2 []types.Log) (equal bool) {
 	if len(logs1) != len(logs2) {
-		logrus.Debugf("logs1 (%d items) is not equal to logs2 (%d items)", len(logs1), len(logs2))
+		log.Debugf("logs1 (%d items) is not equal to logs2 (%d items)", len(logs1), len(logs2))
 		return false
 	}
 
 	for i := 0; i < len(logs1); i++ {
 		if !pool.compareLogs(logs1[i], logs2[i]) {
-			logrus.Debugf("logs1[%d] is not equal to logs2[%d]. \n\tlog1: %v\n\tlog2: %v", i, i, logs1[i], logs2[i])
+			log.Debugf("logs1[%d] is not equal to logs2[%d]. \n\tlog1: %v\n\tlog2: %v", i, i, logs1[i], logs2[i])
 			return false
 		}
 	}
@@ -221,123 +323,160 @@ func (pool *ClientPool) compareListsLogs(logs1 []types.Log, logs2 []types.Log) (
 
 func (pool *ClientPool) compareLogs(log1 types.Log, log2 types.Log) (equal bool) {
 	return log1.TxHash == log2.TxHash &&
-		log1.Index == log2.Index
+		log1.Index == log2.Index &&
+		log1.TxIndex == log2.TxIndex &&
+		log1.BlockNumber == log2.BlockNumber &&
+		log1.BlockHash == log2.BlockHash &&
+		log1.Address == log2.Address &&
+		slices.Equal(log1.Topics, log2.Topics) &&
+		bytes.Equal(log1.Data, log2.Data) &&
+		log1.Removed == log2.Removed
 }
 
-func (pool *ClientPool) getLogs(
-	filterQuery ethereum.FilterQuery,
-	fromBlock, toBlock uint64,
-	specificClient ...*Client,
-) ([]types.Log, error) {
+// GetBlockHeader return the header of the given block, retrying on other clients until ctx is done.
+// A block that does not exist fails with ethereum.NotFound right away, like the other errors that are not retryable
+func (pool *ClientPool) GetBlockHeader(ctx context.Context, blockNumber uint64) (header *types.Header, err error) {
+	ctx, end := pool.startSpan(ctx, "GetBlockHeader", blockAttrs(blockNumber, blockNumber)...)
+	defer func() { end(err) }()
+	ctx = withBlockRange(ctx, "eth_getBlockByNumber", blockNumber, blockNumber)
 	for {
-		client := pool.GetClient()
-		if fromBlock > toBlock {
-			return []types.Log{}, nil
+		client, err := pool.GetClientContext(ctx)
+		if err != nil {
+			return nil, err
 		}
-		filterQuery.FromBlock = big.NewInt(int64(fromBlock))
-		filterQuery.ToBlock = big.NewInt(int64(toBlock))
-		logs, err := client.FilterLogs(context.Background(), filterQuery)
+		header, err := call(ctx, client, func(ctx context.Context) (*types.Header, error) {
+			return client.HeaderByNumber(ctx, new(big.Int).SetUint64(blockNumber))
+		})
 		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if !pool.retryable(err) {
+				return nil, err
+			}
 			client.MarkError(err)
-			logrus.Errorf("Fetch logs [%d to %d] on endpoint %v error: %v", fromBlock, toBlock, client.endpoint, err)
+			log.FromContext(ctx).Errorf("get header of block %d on endpoint %s error: %v", blockNumber, client.Label(), err)
 			continue
 		}
-		if isLogTooLargeError(err) && toBlock > fromBlock {
-			midBlockNumber := fromBlock + (toBlock-fromBlock)/2
-			log1, err1 := pool.getLogs(filterQuery, fromBlock, midBlockNumber)
-			if err1 != nil {
-				return nil, err1
-			}
-			log2, err2 := pool.getLogs(filterQuery, midBlockNumber+1, toBlock)
-			if err2 != nil {
-				return nil, err2
-			}
-			logs = append(log1, log2...)
-			return logs, nil
+		client.MarkSuccess()
+		return header, nil
+	}
+}
+
+// websocketClient return an available client dialed over websocket, nil if there is none
+func (pool *ClientPool) websocketClient() *Client {
+	for _, client := range pool.clients {
+		if client.websocket && client.IsAvailable() {
+			return client
 		}
-		return logs, errors.Wrap(err, "client endpoint: "+client.endpoint)
 	}
+	return nil
+}
+
+// fetchLogs send a single eth_getLogs request for [fromBlock, toBlock] to the client
+func (pool *ClientPool) fetchLogs(
+	ctx context.Context,
+	client *Client,
+	filterQuery ethereum.FilterQuery,
+	fromBlock, toBlock uint64,
+) ([]types.Log, error) {
+	filterQuery.FromBlock = new(big.Int).SetUint64(fromBlock)
+	filterQuery.ToBlock = new(big.Int).SetUint64(toBlock)
+	return call(withBlockRange(ctx, "eth_getLogs", fromBlock, toBlock), client, func(ctx context.Context) ([]types.Log, error) {
+		return client.FilterLogs(ctx, filterQuery)
+	})
 }
 
 func (pool *ClientPool) BlockTime(blockNumber uint64) uint64 {
+	blockTime, _ := pool.BlockTimeContext(context.Background(), blockNumber)
+	return blockTime
+}
+
+// BlockTimeContext return the timestamp of the given block, retrying until ctx is done.
+// Only the block header is fetched, and it is cached by the pool. A block that does not exist
+// fails with ethereum.NotFound right away, like the other errors that are not retryable
+func (pool *ClientPool) BlockTimeContext(ctx context.Context, blockNumber uint64) (blockTime uint64, err error) {
+	if blockTime, ok := pool.headers.blockTime(blockNumber); ok {
+		return blockTime, nil
+	}
+	ctx, end := pool.startSpan(ctx, "BlockTime", blockAttrs(blockNumber, blockNumber)...)
+	defer func() { end(err) }()
+	ctx = withBlockRange(ctx, "eth_getBlockByNumber", blockNumber, blockNumber)
 	if pool.config.ManualBlockTime {
-		return pool.manualBlockTime(blockNumber)
+		blockTime, err = pool.manualBlockTime(ctx, blockNumber)
+	} else {
+		blockTime, err = pool.rpcBlockTime(ctx, blockNumber)
+	}
+	if err != nil {
+		return 0, err
 	}
-	return pool.rpcBlockTime(blockNumber)
+	pool.headers.add(blockNumber, blockTime)
+	return blockTime, nil
 }
 
-func (pool *ClientPool) rpcBlockTime(blockNumber uint64) uint64 {
+func (pool *ClientPool) rpcBlockTime(ctx context.Context, blockNumber uint64) (uint64, error) {
+	if pool.config.Hedge.Enabled {
+		return hedged(ctx, pool, "eth_getBlockByNumber", pool.retryable, func(ctx context.Context, client *Client) (uint64, error) {
+			return client.blockTime(ctx, blockNumber)
+		})
+	}
 	for {
-		ethClient := pool.GetClient()
-		block, err := ethClient.BlockByNumber(context.Background(), big.NewInt(int64(blockNumber)))
+		ethClient, err := pool.GetClientContext(ctx)
 		if err != nil {
-			logrus.Infof(
+			return 0, err
+		}
+		blockTime, err := call(ctx, ethClient, func(ctx context.Context) (uint64, error) {
+			return ethClient.blockTime(ctx, blockNumber)
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return 0, ctx.Err()
+			}
+			if !pool.retryable(err) {
+				return 0, err
+			}
+			log.FromContext(ctx).Infof(
 				"error requesting blocktime from node, backing off. BlockNumber: %v Endpoint: %v, Err: %v,",
 				blockNumber,
-				ethClient.endpoint,
+				ethClient.Label(),
 				err,
 			)
 			ethClient.MarkError(err)
 			continue
 		}
-		return block.Time()
+		ethClient.MarkSuccess()
+		return blockTime, nil
 	}
 }
 
-func (pool *ClientPool) manualBlockTime(blockNumber uint64) uint64 {
+func (pool *ClientPool) manualBlockTime(ctx context.Context, blockNumber uint64) (uint64, error) {
 	for {
-		ethClient := pool.GetClient()
-		url := ethClient.endpoint
-		body := map[string]interface{}{
-			"jsonrpc": "2.0",
-			"method":  "eth_getBlockByNumber",
-			"params": []interface{}{
-				DecimalToHex(int64(blockNumber)),
-				true,
-			},
-			"id": 0,
-		}
-		client := resty.New()
-		res, err := client.R().
-			SetHeader("Content-Type", "application/json").
-			SetBody(body).
-			SetResult(GetBlockTimeResponse{}).
-			Post(url)
+		ethClient, err := pool.GetClientContext(ctx)
 		if err != nil {
-			logrus.Infof(
-				"error manual requesting blocktime from node, backing off. BlockNumber: %v Endpoint: %v, Err: %v,",
-				blockNumber,
-				ethClient.endpoint,
-				err,
-			)
-			ethClient.MarkError(err)
-			continue
+			return 0, err
 		}
-		if res.IsError() {
-			logrus.Infof(
-				"error manual requesting blocktime from node, status code error. BlockNumber: %v Endpoint: %v, Err: %v,",
-				blockNumber,
-				ethClient.endpoint,
-				string(res.Body()),
-			)
-			ethClient.MarkError(err)
-			continue
-		}
-		data := res.Result().(*GetBlockTimeResponse)
-		result, err := HexToInt(data.Result.Timestamp)
+		blockTime, err := call(ctx, ethClient, func(ctx context.Context) (uint64, error) {
+			return ethClient.manualBlockTime(ctx, blockNumber)
+		})
 		if err != nil {
-			logrus.Infof(
-				"error manual requesting blocktime from node, hex to int. BlockNumber: %v Endpoint: %v, Err: %v,",
+			if ctx.Err() != nil {
+				return 0, ctx.Err()
+			}
+			if !pool.retryable(err) {
+				return 0, err
+			}
+			log.FromContext(ctx).Infof(
+				"error manual requesting blocktime from node, backing off. BlockNumber: %v Endpoint: %v, Err: %v,",
 				blockNumber,
-				ethClient.endpoint,
+				ethClient.Label(),
 				err,
 			)
 			ethClient.MarkError(err)
 			continue
 		}
-		return uint64(result)
+		ethClient.MarkSuccess()
+		return blockTime, nil
 	}
-
 }
 
 func (pool *ClientPool) GetToBlock(fromRange int64, maxToBlock int64) int64 {
@@ -348,145 +487,109 @@ func (pool *ClientPool) GetToBlock(fromRange int64, maxToBlock int64) int64 {
 }
 
 func (pool *ClientPool) GetTransactionReceipt(txHash common.Hash) (*types.Receipt, error) {
-	for {
-		client := pool.GetClient()
-		receipt, err := client.TransactionReceipt(context.Background(), txHash)
-		if err != nil {
-			if isRateLimit(err) {
-				client.MarkError(err)
-				logrus.Errorf("get tx receipt error: %v", err)
-				continue
-			} else {
-				return nil, err
-			}
-		}
-		return receipt, nil
-	}
+	return pool.GetTransactionReceiptContext(context.Background(), txHash)
 }
 
-func (pool *ClientPool) GetTokenInfo(tokenAddress string) (*model.TokenInfo, error) {
+// GetTransactionReceiptContext is GetTransactionReceipt that stops retrying once ctx is done
+func (pool *ClientPool) GetTransactionReceiptContext(ctx context.Context, txHash common.Hash) (receipt *types.Receipt, err error) {
+	ctx, end := pool.startSpan(ctx, "GetTransactionReceipt", attrTxHash.String(txHash.Hex()))
+	defer func() { end(err) }()
+	ctx = withMethod(ctx, "eth_getTransactionReceipt")
+	if pool.config.Hedge.Enabled {
+		return hedged(ctx, pool, "eth_getTransactionReceipt", pool.retryable, func(ctx context.Context, client *Client) (*types.Receipt, error) {
+			return client.TransactionReceipt(ctx, txHash)
+		})
+	}
 	for {
-		item := &model.TokenInfo{}
-		client := pool.GetClient()
-		clientWeb3, err := web3.NewWeb3(client.endpoint)
+		client, err := pool.GetClientContext(ctx)
 		if err != nil {
-			if isRateLimit(err) {
-				client.MarkError(err)
-				logrus.Errorf("init web 3 client error: %v", err)
-				continue
-			} else {
-				return nil, err
-			}
-		}
-		contract, err := clientWeb3.Eth.NewContract(tokenInfoABI, tokenAddress)
-		if err != nil {
-			if isRateLimit(err) {
-				client.MarkError(err)
-				logrus.Errorf("init contract error: %v", err)
-				continue
-			} else {
-				return nil, err
-			}
-		}
-		name, err := contract.Call("name")
-		if err != nil {
-			if isRateLimit(err) {
-				client.MarkError(err)
-				logrus.Errorf("get contract name error: %v", err)
-				continue
-			} else {
-				return nil, err
-			}
+			return nil, err
 		}
-		symbol, err := contract.Call("symbol")
+		receipt, err := call(ctx, client, func(ctx context.Context) (*types.Receipt, error) {
+			return client.TransactionReceipt(ctx, txHash)
+		})
 		if err != nil {
-			if isRateLimit(err) {
+			if pool.retryable(err) && ctx.Err() == nil {
 				client.MarkError(err)
-				logrus.Errorf("get contract symbol error: %v", err)
+				log.FromContext(ctx).Errorf("get tx receipt error: %v", err)
 				continue
 			} else {
 				return nil, err
 			}
 		}
+		client.MarkSuccess()
+		return receipt, nil
+	}
+}
 
-		decimals, err := contract.Call("decimals")
-		if err != nil {
-			if isRateLimit(err) {
-				client.MarkError(err)
-				logrus.Errorf("get contract decimals error: %v", err)
-				continue
-			} else {
-				return nil, err
-			}
-		}
+func (pool *ClientPool) GetTokenInfo(tokenAddress string) (*model.TokenInfo, error) {
+	return pool.GetTokenInfoContext(context.Background(), tokenAddress)
+}
 
-		totalSupply, err := contract.Call("totalSupply")
-		if err != nil {
-			if isRateLimit(err) {
-				client.MarkError(err)
-				logrus.Errorf("get contract total supply error: %v", err)
-				continue
-			} else {
-				return nil, err
-			}
-		}
-		item.TokenName = name.(string)
-		item.TokenSymbol = symbol.(string)
-		item.TokenAddress = tokenAddress
-		item.ContractDecimals = int64(decimals.(uint8))
-		item.TotalSupply = utils.BigIntToFloat(totalSupply.(*big.Int), item.ContractDecimals)
-		return item, err
+// GetTokenInfoContext is GetTokenInfo that stops retrying once ctx is done
+func (pool *ClientPool) GetTokenInfoContext(ctx context.Context, tokenAddress string) (*model.TokenInfo, error) {
+	tokens, err := pool.GetTokenInfos(ctx, []string{tokenAddress})
+	var batchErr BatchError[string]
+	if errors.As(err, &batchErr) {
+		return nil, batchErr[tokenAddress]
 	}
+	if err != nil {
+		return nil, err
+	}
+	return tokens[tokenAddress], nil
 }
 
 func (pool *ClientPool) GetLiquidityPoolInfo(poolAddress string) (*model.LiquidityPoolInfo, error) {
-	for {
-		item := &model.LiquidityPoolInfo{}
-		client := pool.GetClient()
-		clientWeb3, err := web3.NewWeb3(client.endpoint)
-		if err != nil {
-			if isRateLimit(err) {
-				client.MarkError(err)
-				logrus.Errorf("init web 3 client error: %v", err)
-				continue
-			} else {
-				return nil, err
-			}
-		}
-		contract, err := clientWeb3.Eth.NewContract(liquidityPoolInfoABI, poolAddress)
-		if err != nil {
-			if isRateLimit(err) {
-				client.MarkError(err)
-				logrus.Errorf("init contract error: %v", err)
-				continue
-			} else {
-				return nil, err
-			}
-		}
-		token0, err := contract.Call("token0")
-		if err != nil {
-			if isRateLimit(err) {
-				client.MarkError(err)
-				logrus.Errorf("get contract name error: %v", err)
-				continue
-			} else {
-				return nil, err
-			}
-		}
-		token1, err := contract.Call("token1")
-		if err != nil {
-			if isRateLimit(err) {
-				client.MarkError(err)
-				logrus.Errorf("get contract symbol error: %v", err)
-				continue
-			} else {
-				return nil, err
-			}
-		}
+	return pool.GetLiquidityPoolInfoContext(context.Background(), poolAddress)
+}
+
+// GetLiquidityPoolInfoContext is GetLiquidityPoolInfo that stops retrying once ctx is done
+func (pool *ClientPool) GetLiquidityPoolInfoContext(ctx context.Context, poolAddress string) (*model.LiquidityPoolInfo, error) {
+	pools, err := pool.GetLiquidityPoolInfos(ctx, []string{poolAddress})
+	var batchErr BatchError[string]
+	if errors.As(err, &batchErr) {
+		return nil, batchErr[poolAddress]
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pools[poolAddress], nil
+}
+
+// waitForChange pause the current goroutine until wait is over, wakeup is closed or ctx is done.
+// A negative wait means there is no known deadline, so it is bounded by the probe timeout
+func waitForChange(ctx context.Context, wait time.Duration, wakeup <-chan struct{}) error {
+	if wait < 0 {
+		wait = probeTimeout
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+	case <-wakeup:
+	}
+	return nil
+}
+
+// headerValues flatten http headers into the single value form used by resty
+func headerValues(headers http.Header) map[string]string {
+	values := make(map[string]string, len(headers))
+	for key := range headers {
+		values[key] = headers.Get(key)
+	}
+	return values
+}
 
-		item.Token0 = token0.(common.Address).String()
-		item.Token1 = token1.(common.Address).String()
-		item.PoolAddress = poolAddress
-		return item, err
+// SleepContext pause the current goroutine for d or until ctx is done, it returns ctx error in the latter case
+func SleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
 	}
 }
@@ -0,0 +1,291 @@
+package client_pool_test
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/duongtuttbn/toolkit/client_pool"
+	"github.com/duongtuttbn/toolkit/client_pool/rpctest"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+var transferTopic = common.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+
+func newPool(t *testing.T, cfg client_pool.Config, servers ...*rpctest.Server) *client_pool.ClientPool {
+	t.Helper()
+	for _, server := range servers {
+		cfg.Endpoints = append(cfg.Endpoints, client_pool.EndpointConfig{URL: server.URL, Label: server.URL})
+	}
+	pool, err := client_pool.NewBasicClientPool(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
+
+// fixture is a chain served by rpctest servers, with the context of the test
+type fixture struct {
+	ctx     context.Context
+	chain   *rpctest.Chain
+	servers []*rpctest.Server
+}
+
+func newFixture(t *testing.T, head uint64, servers int) *fixture {
+	t.Helper()
+	f := &fixture{ctx: testContext(t), chain: rpctest.NewChain(head)}
+	for i := 0; i < servers; i++ {
+		f.servers = append(f.servers, newServer(t, f.chain))
+	}
+	return f
+}
+
+// pool build a pool over every server of the fixture, servers may be scripted before
+func (f *fixture) pool(t *testing.T, cfg client_pool.Config) *client_pool.ClientPool {
+	t.Helper()
+	return newPool(t, cfg, f.servers...)
+}
+
+// testContext return a context cancelled when the test ends, or after 5s so a stuck call fail the test
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func newServer(t *testing.T, chain *rpctest.Chain) *rpctest.Server {
+	t.Helper()
+	server := rpctest.NewServer(chain)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func addTransfers(chain *rpctest.Chain, blocks ...uint64) {
+	for _, block := range blocks {
+		chain.AddLogs(types.Log{
+			Address:     common.HexToAddress("0x1"),
+			Topics:      []common.Hash{transferTopic},
+			BlockNumber: block,
+			TxHash:      common.BigToHash(new(big.Int).SetUint64(block)),
+		})
+	}
+}
+
+func TestGetLatestBlockFailover(t *testing.T) {
+	chain := rpctest.NewChain(100)
+	down, up := newServer(t, chain), newServer(t, chain)
+	down.SetDown(true)
+	pool := newPool(t, client_pool.Config{Backoff: client_pool.ConstantBackoff(time.Minute)}, down, up)
+
+	ctx := testContext(t)
+	for i := 0; i < 3; i++ {
+		block, err := pool.GetLatestBlockContext(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block != 100 {
+			t.Fatalf("got block %d, want 100", block)
+		}
+	}
+	clients := pool.GetAllClients()
+	if state := clients[0].State(); state != client_pool.CircuitOpen {
+		t.Fatalf("down client is %s, want open", state)
+	}
+	if state := clients[1].State(); state != client_pool.CircuitClosed {
+		t.Fatalf("up client is %s, want closed", state)
+	}
+	if requests := up.Requests("eth_blockNumber"); requests != 3 {
+		t.Fatalf("up server got %d requests, want 3", requests)
+	}
+}
+
+func TestGetClientContextCancelledWhileBenched(t *testing.T) {
+	f := newFixture(t, 100, 2)
+	pool := f.pool(t, client_pool.Config{Backoff: client_pool.ConstantBackoff(time.Minute)})
+	for _, client := range pool.GetAllClients() {
+		client.MarkError(errors.New("internal error"))
+	}
+
+	ctx, cancel := context.WithTimeout(f.ctx, 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := pool.GetClientContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want the deadline of the context", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("returned %v after the context was done", elapsed)
+	}
+}
+
+func TestGetClientContextWakesUpOnRecovery(t *testing.T) {
+	f := newFixture(t, 100, 1)
+	// the probe is slow, so the waiter is woken up by the probe result rather than by its bench timer
+	f.servers[0].SetLatency(50 * time.Millisecond)
+	pool := f.pool(t, client_pool.Config{Backoff: client_pool.ConstantBackoff(20 * time.Millisecond)})
+	client := pool.GetAllClients()[0]
+	client.MarkError(errors.New("internal error"))
+
+	start := time.Now()
+	got, err := pool.GetClientContext(f.ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// without the wakeup, the waiter would sleep for the 10s probe timeout once the circuit is half-open
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("got the client after %v, want it as soon as the probe succeeded", elapsed)
+	}
+	if got != client || client.State() != client_pool.CircuitClosed {
+		t.Fatalf("got a %s client, want the recovered one closed", client.State())
+	}
+}
+
+func TestGetLogsSplitsLargeRanges(t *testing.T) {
+	chain := rpctest.NewChain(1000)
+	addTransfers(chain, 10, 250, 500, 999)
+	server := newServer(t, chain)
+	server.SetMaxLogRange(100)
+	pool := newPool(t, client_pool.Config{}, server)
+
+	ctx := testContext(t)
+	logs, err := pool.GetLogsContext(ctx, ethereum.FilterQuery{Topics: [][]common.Hash{{transferTopic}}}, 0, 1000, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 4 {
+		t.Fatalf("got %d logs, want 4", len(logs))
+	}
+	for i, block := range []uint64{10, 250, 500, 999} {
+		if logs[i].BlockNumber != block {
+			t.Fatalf("log %d is in block %d, want %d", i, logs[i].BlockNumber, block)
+		}
+	}
+	// the window grows back by a quarter after each success, so chunks stay close to the 100 blocks limit
+	if requests := server.Requests("eth_getLogs"); requests < 9 {
+		t.Fatalf("server got %d eth_getLogs, want the range split in at least 8 chunks", requests)
+	}
+}
+
+func TestGetLogsConsistency(t *testing.T) {
+	chain := rpctest.NewChain(100)
+	addTransfers(chain, 10, 20, 30)
+	honest, tampered := newServer(t, chain), newServer(t, chain)
+	pool := newPool(t, client_pool.Config{}, honest, tampered)
+	query := ethereum.FilterQuery{Topics: [][]common.Hash{{transferTopic}}}
+
+	ctx := testContext(t)
+	logs, err := pool.GetLogsContext(ctx, query, 0, 100, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 3 {
+		t.Fatalf("got %d logs, want 3", len(logs))
+	}
+
+	tampered.TamperLogs(func(logs []types.Log) []types.Log {
+		return logs[:len(logs)-1]
+	})
+	_, err = pool.GetLogsContext(ctx, query, 0, 100, 2)
+	var consistencyErr *client_pool.ConsistencyError
+	if !errors.As(err, &consistencyErr) {
+		t.Fatalf("got error %v, want a consistency error", err)
+	}
+	if consistencyErr.Agreed != 1 {
+		t.Fatalf("%d endpoints agreed, want 1", consistencyErr.Agreed)
+	}
+
+	// every voter split a range larger than what its endpoint serve, instead of failing and benching it
+	large := newFixture(t, 1000, 2)
+	addTransfers(large.chain, 10, 250, 500, 999)
+	for _, server := range large.servers {
+		server.SetMaxLogRange(100)
+	}
+	pool = large.pool(t, client_pool.Config{})
+	logs, err = pool.GetLogsContext(large.ctx, query, 0, 1000, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 4 {
+		t.Fatalf("got %d logs over the large range, want 4", len(logs))
+	}
+	for _, client := range pool.GetAllClients() {
+		if state := client.State(); state != client_pool.CircuitClosed {
+			t.Fatalf("endpoint %s is %s after splitting the range, want closed", client.Label(), state)
+		}
+	}
+}
+
+func TestBackoffRestoresClient(t *testing.T) {
+	chain := rpctest.NewChain(100)
+	server := newServer(t, chain)
+	server.Fail("eth_blockNumber", 3, &rpctest.Error{Code: -32005, Message: "rate limit exceeded"})
+	backoff := client_pool.ExponentialBackoff{Initial: 20 * time.Millisecond, Max: time.Second, Multiplier: 2, ResetAfter: 1}
+	pool := newPool(t, client_pool.Config{Backoff: backoff}, server)
+
+	ctx := testContext(t)
+	start := time.Now()
+	block, err := pool.GetLatestBlockContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block != 100 {
+		t.Fatalf("got block %d, want 100", block)
+	}
+	// the request fails, then the probes after the 20ms and 40ms benches fail and the one after 80ms succeeds
+	if elapsed := time.Since(start); elapsed < 140*time.Millisecond {
+		t.Fatalf("recovered after %v, want at least 140ms of backoff", elapsed)
+	}
+	if requests := server.Requests("eth_blockNumber"); requests != 5 {
+		t.Fatalf("server got %d requests, want 5", requests)
+	}
+	if state := pool.GetAllClients()[0].State(); state != client_pool.CircuitClosed {
+		t.Fatalf("client is %s, want closed", state)
+	}
+}
+
+func TestNonRetryableErrorsAreReturned(t *testing.T) {
+	f := newFixture(t, 100, 2)
+	for _, server := range f.servers {
+		server.Fail("eth_getLogs", -1, &rpctest.Error{Code: -32602, Message: "invalid params"})
+	}
+	for _, manual := range []bool{false, true} {
+		pool := f.pool(t, client_pool.Config{ManualBlockTime: manual, Backoff: client_pool.ConstantBackoff(time.Minute)})
+		// a request the endpoints reject for good is not retried until the deadline
+		_, err := pool.GetLogsContext(f.ctx, ethereum.FilterQuery{}, 0, 100, 1)
+		if err == nil || errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "invalid params") {
+			t.Fatalf("got error %v, want the invalid params error", err)
+		}
+		// a block above the head does not exist yet, on any endpoint
+		if _, err := pool.BlockTimeContext(f.ctx, 200); !errors.Is(err, ethereum.NotFound) {
+			t.Fatalf("got block time error %v (manual %v), want ethereum.NotFound", err, manual)
+		}
+		if _, err := pool.GetBlockHeader(f.ctx, 200); !errors.Is(err, ethereum.NotFound) {
+			t.Fatalf("got header error %v, want ethereum.NotFound", err)
+		}
+		for _, client := range pool.GetAllClients() {
+			if state := client.State(); state != client_pool.CircuitClosed {
+				t.Fatalf("endpoint %s is %s, want it not benched for the caller errors", client.Label(), state)
+			}
+		}
+	}
+}
+
+func TestRetryAfterBenchesClient(t *testing.T) {
+	chain := rpctest.NewChain(100)
+	limited, spare := newServer(t, chain), newServer(t, chain)
+	limited.RateLimit(1, 2*time.Second)
+	pool := newPool(t, client_pool.Config{Backoff: client_pool.ConstantBackoff(time.Millisecond)}, limited, spare)
+
+	ctx := testContext(t)
+	if _, err := pool.GetLatestBlockContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	client := pool.GetAllClients()[0]
+	if client.IsAvailable() {
+		t.Fatal("rate limited client is available before its Retry-After")
+	}
+}
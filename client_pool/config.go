@@ -1,7 +1,161 @@
 package client_pool
 
-type Config struct {
-	// List of RPC URL with comma separate
-	RpcUrls         string
-	ManualBlockTime bool
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/yaml.v3"
+)
+
+type (
+	Config struct {
+		// List of RPC URL with comma separate, kept for backward compatibility with Endpoints
+		RpcUrls string `json:"rpc_urls" yaml:"rpc_urls"`
+		// Endpoints describe the RPC endpoints one by one, they are used together with RpcUrls
+		Endpoints       []EndpointConfig `json:"endpoints" yaml:"endpoints"`
+		ManualBlockTime bool             `json:"manual_block_time" yaml:"manual_block_time"`
+		// Backoff decide how long a failing endpoint is benched, DefaultBackoff is used when nil
+		Backoff BackoffPolicy `json:"-" yaml:"-"`
+		// Strategy is the built-in client selection strategy: round_robin (default), weighted,
+		// least_in_flight or latency
+		Strategy string `json:"strategy" yaml:"strategy"`
+		// Selector override Strategy with a custom client selection
+		Selector Selector `json:"-" yaml:"-"`
+		// Weights of the RPC URLs for the weighted strategy, missing URLs have weight 1
+		Weights map[string]int `json:"weights" yaml:"weights"`
+		Hedge   HedgeConfig    `json:"hedge" yaml:"hedge"`
+		// LogRange tune how GetLogs split large block ranges
+		LogRange LogRangeConfig `json:"log_range" yaml:"log_range"`
+		// Multicall tune the Multicall3 batches used for token and pool metadata
+		Multicall MulticallConfig `json:"multicall" yaml:"multicall"`
+		// PoolState hold the chain specific contracts used to read pool states
+		PoolState PoolStateConfig `json:"pool_state" yaml:"pool_state"`
+		// HeaderCache size the cache of block headers used by BlockTime and BlockAtTimestamp
+		HeaderCache HeaderCacheConfig `json:"header_cache" yaml:"header_cache"`
+		// Receipt tune how WaitForReceipt poll the transaction receipts
+		Receipt ReceiptConfig `json:"receipt" yaml:"receipt"`
+		// ErrorRules classify provider specific errors, they are matched before the built-in rules
+		ErrorRules []ErrorRule `json:"error_rules" yaml:"error_rules"`
+		// ChainID is the chain every endpoint must serve, default to the chain served by most endpoints
+		ChainID uint64 `json:"chain_id" yaml:"chain_id"`
+		// ChainIDCheck is what happens to the endpoints serving another chain: reject (default) fail
+		// the pool construction, quarantine take them out of rotation and skip disable the check.
+		// Endpoints that could only be checked after the construction are quarantined
+		ChainIDCheck string `json:"chain_id_check" yaml:"chain_id_check"`
+		// TracerProvider enable the OpenTelemetry spans of the pool calls and of their attempts
+		TracerProvider trace.TracerProvider `json:"-" yaml:"-"`
+	}
+
+	EndpointConfig struct {
+		URL string `json:"url" yaml:"url"`
+		// Label name the endpoint in logs, default to the redacted URL. Endpoints of a pool sharing a label
+		// get their index appended
+		Label string `json:"label" yaml:"label"`
+		// Proxy is the URL of the HTTP proxy used to reach the endpoint
+		Proxy   string            `json:"proxy" yaml:"proxy"`
+		Headers map[string]string `json:"headers" yaml:"headers"`
+		// APIKey is sent as a bearer token, or in the APIKeyHeader header when it is set
+		APIKey       string `json:"api_key" yaml:"api_key"`
+		APIKeyHeader string `json:"api_key_header" yaml:"api_key_header"`
+		// Weight of the endpoint for the weighted strategy, default to 1
+		Weight    int             `json:"weight" yaml:"weight"`
+		RateLimit RateLimitConfig `json:"rate_limit" yaml:"rate_limit"`
+		// LogWindow is the number of blocks first requested in one eth_getLogs, default to LogRangeConfig.InitialWindow
+		LogWindow uint64 `json:"log_window" yaml:"log_window"`
+		// MaxBatchSize is the largest number of calls sent in one JSON-RPC batch, default to 100
+		MaxBatchSize int `json:"max_batch_size" yaml:"max_batch_size"`
+	}
+
+	RateLimitConfig struct {
+		// RequestsPerSecond allowed against the endpoint, zero means unlimited. Every call of a JSON-RPC batch count
+		RequestsPerSecond float64 `json:"requests_per_second" yaml:"requests_per_second"`
+		// Burst is the number of requests that may be sent at once, default to 1
+		Burst int `json:"burst" yaml:"burst"`
+	}
+)
+
+// LoadConfig read a Config from a JSON or YAML file, depending on its extension
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, errors.Wrap(err, "unable to read client pool config")
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, &cfg)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &cfg)
+	default:
+		return cfg, errors.Errorf("unsupported client pool config format: %s", path)
+	}
+	if err != nil {
+		return cfg, errors.Wrap(err, "unable to decode client pool config")
+	}
+	return cfg, nil
+}
+
+// ConfigFromEnv read a Config from the environment variables:
+//   - <prefix>RPC_URLS: comma separated RPC URLs
+//   - <prefix>ENDPOINTS: JSON array of endpoint configs
+//   - <prefix>MANUAL_BLOCK_TIME: true or false
+//   - <prefix>STRATEGY: client selection strategy
+func ConfigFromEnv(prefix string) (Config, error) {
+	cfg := Config{
+		RpcUrls:  os.Getenv(prefix + "RPC_URLS"),
+		Strategy: os.Getenv(prefix + "STRATEGY"),
+	}
+	if endpoints := os.Getenv(prefix + "ENDPOINTS"); endpoints != "" {
+		if err := json.Unmarshal([]byte(endpoints), &cfg.Endpoints); err != nil {
+			return cfg, errors.Wrapf(err, "unable to decode %sENDPOINTS", prefix)
+		}
+	}
+	if manualBlockTime := os.Getenv(prefix + "MANUAL_BLOCK_TIME"); manualBlockTime != "" {
+		var err error
+		cfg.ManualBlockTime, err = strconv.ParseBool(manualBlockTime)
+		if err != nil {
+			return cfg, errors.Wrapf(err, "unable to parse %sMANUAL_BLOCK_TIME", prefix)
+		}
+	}
+	return cfg, nil
+}
+
+// httpHeaders return the headers sent with every request to the endpoint
+func (cfg EndpointConfig) httpHeaders() http.Header {
+	headers := http.Header{}
+	for key, value := range cfg.Headers {
+		headers.Set(key, value)
+	}
+	if cfg.APIKey != "" {
+		if cfg.APIKeyHeader != "" {
+			headers.Set(cfg.APIKeyHeader, cfg.APIKey)
+		} else {
+			headers.Set("Authorization", "Bearer "+cfg.APIKey)
+		}
+	}
+	return headers
+}
+
+// endpointConfigs return the endpoints of Endpoints followed by the ones of RpcUrls
+func (cfg Config) endpointConfigs() []EndpointConfig {
+	endpoints := make([]EndpointConfig, 0, len(cfg.Endpoints))
+	endpoints = append(endpoints, cfg.Endpoints...)
+	for _, rpcUrl := range strings.Split(cfg.RpcUrls, ",") {
+		rpcUrl = strings.TrimSpace(rpcUrl)
+		if rpcUrl != "" {
+			endpoints = append(endpoints, EndpointConfig{URL: rpcUrl})
+		}
+	}
+	for i := range endpoints {
+		if endpoints[i].Weight == 0 {
+			endpoints[i].Weight = cfg.Weights[endpoints[i].URL]
+		}
+	}
+	return endpoints
 }
@@ -1,32 +1,284 @@
 package client_pool
 
 import (
+	"context"
+	"io"
+	"net"
 	"net/http"
 	"strings"
 
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/pkg/errors"
 )
 
-func isLogTooLargeError(err error) bool {
-	if err == nil {
-		return false
+// ErrorClass is the kind of failure behind an RPC error, the pool retry logic act on it
+type ErrorClass int
+
+const (
+	// ClassUnknown is an error no rule recognised
+	ClassUnknown ErrorClass = iota
+	// ClassRateLimited means the endpoint rejected the request because of its quota or rate limit
+	ClassRateLimited
+	// ClassRangeTooLarge means the eth_getLogs block range or result is too large for the endpoint
+	ClassRangeTooLarge
+	// ClassBatchTooLarge means the JSON-RPC batch has more calls than the endpoint accept
+	ClassBatchTooLarge
+	// ClassNotFound means the requested block, transaction or receipt does not exist
+	ClassNotFound
+	// ClassExecutionReverted means the EVM call reverted
+	ClassExecutionReverted
+	// ClassTimeout means the request or the endpoint timed out
+	ClassTimeout
+	// ClassNetwork means the endpoint could not be reached or answered with a server error
+	ClassNetwork
+	// ClassAuth means the endpoint rejected the credentials
+	ClassAuth
+	// ClassNonceTooLow means a transaction was sent with a nonce already used by a mined transaction
+	ClassNonceTooLow
+	// ClassAlreadyKnown means the transaction sent is already in the endpoint pool
+	ClassAlreadyKnown
+	// ClassUnderpriced means the fees of a transaction, or of a replacement, are too low
+	ClassUnderpriced
+	// ClassNotSynced means the endpoint does not have the requested block yet, another endpoint may have it
+	ClassNotSynced
+)
+
+// ErrorRule classify the errors that match any of its codes, statuses or substrings
+type ErrorRule struct {
+	Class ErrorClass `json:"class" yaml:"class"`
+	// Codes are JSON-RPC error codes
+	Codes []int `json:"codes" yaml:"codes"`
+	// Statuses are HTTP status codes
+	Statuses []int `json:"statuses" yaml:"statuses"`
+	// Contains are substrings of the error message, matched case-insensitively
+	Contains []string `json:"contains" yaml:"contains"`
+}
+
+// ErrEmptyPool is returned when a client is requested from a pool without any client
+var ErrEmptyPool = errors.New("client pool has no client")
+
+var errorClassNames = map[ErrorClass]string{
+	ClassUnknown:           "unknown",
+	ClassRateLimited:       "rate_limited",
+	ClassRangeTooLarge:     "range_too_large",
+	ClassBatchTooLarge:     "batch_too_large",
+	ClassNotFound:          "not_found",
+	ClassExecutionReverted: "execution_reverted",
+	ClassTimeout:           "timeout",
+	ClassNetwork:           "network",
+	ClassAuth:              "auth",
+	ClassNonceTooLow:       "nonce_too_low",
+	ClassAlreadyKnown:      "already_known",
+	ClassUnderpriced:       "underpriced",
+	ClassNotSynced:         "not_synced",
+}
+
+// defaultErrorRules are matched in order, so the range and batch messages win over the generic rate limit
+// code -32005 that infura also use for too many results
+var defaultErrorRules = []ErrorRule{
+	{
+		Class: ClassRangeTooLarge,
+		Contains: []string{
+			"query returned more than", // infura, alchemy result size limit
+			"response size exceeded",
+			"maximum block range",
+			"block range is too",
+			"block range too large",
+			"range too large", // cloudflare-eth.com
+		},
+	},
+	{
+		Class:    ClassBatchTooLarge,
+		Statuses: []int{http.StatusRequestEntityTooLarge},
+		Contains: []string{
+			"batch too large", // geth
+			"batch size",      // batch size too large, exceeds max batch size
+			"batch limit",
+			// the endpoint answered the whole batch with a single error object, as geth does when the batch is too large
+			"cannot unmarshal object into go value of type []",
+		},
+	},
+	{
+		Class:    ClassNonceTooLow,
+		Contains: []string{"nonce too low", "nonce is too low", "nonce has already been used"},
+	},
+	{
+		Class: ClassAlreadyKnown,
+		Contains: []string{
+			"already known", // geth
+			"known transaction",
+			"already imported",
+			"already exists",
+		},
+	},
+	{
+		Class: ClassUnderpriced,
+		Contains: []string{
+			"underpriced", // transaction underpriced, replacement transaction underpriced
+			"fee too low",
+			"less than block base fee",
+		},
+	},
+	{
+		Class:    ClassRateLimited,
+		Codes:    []int{-32005, -32429},
+		Statuses: []int{http.StatusTooManyRequests},
+		Contains: []string{
+			"exceeded the quota usage",
+			"limit exceeded", // getblock.io
+			"exceeded limit",
+			"rate limit",
+			"too many requests",
+			"compute units per second",
+			"unable to perform request",
+			"order a dedicated full node",
+		},
+	},
+	{
+		Class:    ClassExecutionReverted,
+		Codes:    []int{3},
+		Contains: []string{"execution reverted"},
+	},
+	{
+		Class:    ClassAuth,
+		Statuses: []int{http.StatusUnauthorized, http.StatusForbidden},
+		Contains: []string{"unauthorized", "forbidden", "invalid api key", "invalid project id"},
+	},
+	{
+		Class:    ClassTimeout,
+		Statuses: []int{http.StatusRequestTimeout, http.StatusGatewayTimeout},
+		Contains: []string{"timeout", "timed out", "deadline exceeded"},
+	},
+	{
+		Class:    ClassNetwork,
+		Statuses: []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable},
+		Contains: []string{"connection refused", "connection reset", "no such host", "broken pipe", "unexpected eof"},
+	},
+	{
+		Class: ClassNotSynced,
+		Contains: []string{
+			"header not found", // geth, for a block number above its head
+			"unknown block",
+		},
+	},
+	{
+		Class: ClassNotFound,
+		Codes: []int{-32001}, // infura resource not found
+		Contains: []string{
+			"transaction not found",
+			"receipt not found", // receipt not found, transaction receipt not found
+			"unknown transaction",
+		},
+	},
+}
+
+func (c ErrorClass) String() string {
+	if name, ok := errorClassNames[c]; ok {
+		return name
 	}
-	if _, ok := err.(rpc.Error); (ok && err.(rpc.Error).ErrorCode() == -32005 && strings.Contains(err.Error(), "10000")) || // rate limit error infura
-		strings.Contains(err.Error(), "limit exceeded") || // rate limit getblockio
-		strings.Contains(err.Error(), "range too large") { // rate limit cloudflare-eth.com
-		return true
+	return "unknown"
+}
+
+// MarshalText encode the class by name, so rules can be written in config files
+func (c ErrorClass) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+func (c *ErrorClass) UnmarshalText(text []byte) error {
+	for class, name := range errorClassNames {
+		if strings.EqualFold(name, string(text)) {
+			*c = class
+			return nil
+		}
 	}
-	return false
+	return errors.Errorf("unknown error class %q", text)
 }
 
-func isRateLimit(err error) bool {
-	if _, ok := err.(rpc.HTTPError); ok && (err.(rpc.HTTPError).StatusCode == http.StatusTooManyRequests || err.(rpc.HTTPError).StatusCode == -32429) ||
-		strings.Contains(err.Error(), "Exceeded the quota usage") ||
-		strings.Contains(err.Error(), "limit exceeded") ||
-		strings.Contains(err.Error(), "exceeded limit") ||
-		strings.Contains(err.Error(), "Unable to perform request") ||
-		strings.Contains(err.Error(), "order a dedicated full node") {
+// Retryable report whether a request that failed with the class may succeed later or on another endpoint
+func (c ErrorClass) Retryable() bool {
+	switch c {
+	case ClassRateLimited, ClassTimeout, ClassNetwork, ClassAuth, ClassNotSynced:
 		return true
+	default:
+		return false
+	}
+}
+
+// Classify return the class of an RPC error using the built-in rules
+func Classify(err error) ErrorClass {
+	return classify(err, nil)
+}
+
+// Classify return the class of an RPC error, using the rules of Config.ErrorRules before the built-in ones
+func (pool *ClientPool) Classify(err error) ErrorClass {
+	return classify(err, pool.config.ErrorRules)
+}
+
+// retryable report whether the request that failed with err should be sent again
+func (pool *ClientPool) retryable(err error) bool {
+	return pool.Classify(err).Retryable()
+}
+
+func classify(err error, rules []ErrorRule) ErrorClass {
+	if err == nil {
+		return ClassUnknown
+	}
+	if class, ok := matchErrorRules(err, rules); ok {
+		return class
+	}
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return ClassTimeout
+	case errors.Is(err, ethereum.NotFound):
+		return ClassNotFound
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), netErr != nil:
+		return ClassNetwork
+	}
+	if class, ok := matchErrorRules(err, defaultErrorRules); ok {
+		return class
+	}
+	return ClassUnknown
+}
+
+func matchErrorRules(err error, rules []ErrorRule) (ErrorClass, bool) {
+	if len(rules) == 0 {
+		return ClassUnknown, false
+	}
+	var code, status int
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) {
+		code = rpcErr.ErrorCode()
+	}
+	var httpErr rpc.HTTPError
+	if errors.As(err, &httpErr) {
+		status = httpErr.StatusCode
+	}
+	message := strings.ToLower(err.Error())
+	for _, rule := range rules {
+		if rule.matches(code, status, message) {
+			return rule.Class, true
+		}
+	}
+	return ClassUnknown, false
+}
+
+func (rule ErrorRule) matches(code, status int, message string) bool {
+	for _, c := range rule.Codes {
+		if code != 0 && c == code {
+			return true
+		}
+	}
+	for _, s := range rule.Statuses {
+		if status != 0 && s == status {
+			return true
+		}
+	}
+	for _, substring := range rule.Contains {
+		if substring != "" && strings.Contains(message, strings.ToLower(substring)) {
+			return true
+		}
 	}
 	return false
 }
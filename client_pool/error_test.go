@@ -0,0 +1,99 @@
+package client_pool
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/pkg/errors"
+)
+
+// codeError is a JSON-RPC error object as decoded by the rpc client
+type codeError struct {
+	code    int
+	message string
+}
+
+func (e codeError) Error() string  { return e.message }
+func (e codeError) ErrorCode() int { return e.code }
+
+func httpError(status int, body string) error {
+	return rpc.HTTPError{StatusCode: status, Status: fmt.Sprintf("%d %s", status, http.StatusText(status)), Body: []byte(body)}
+}
+
+func TestClassify(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		err  error
+		want ErrorClass
+	}{
+		{"nil", nil, ClassUnknown},
+		{"method not found", codeError{-32601, "method not found"}, ClassUnknown},
+		{"method not available", codeError{-32601, "the method eth_foo does not exist/is not available"}, ClassUnknown},
+		{"header not found", codeError{-32000, "header not found"}, ClassNotSynced},
+		{"unknown block", codeError{-32000, "unknown block"}, ClassNotSynced},
+		{"transaction not found", codeError{-32000, "transaction not found"}, ClassNotFound},
+		{"receipt not found", codeError{-32000, "transaction receipt not found"}, ClassNotFound},
+		{"infura resource not found", codeError{-32001, "resource not found"}, ClassNotFound},
+		{"ethereum not found", errors.Wrap(ethereum.NotFound, "get receipt"), ClassNotFound},
+		{"range too large before the -32005 rate limit", codeError{-32005, "query returned more than 10000 results"}, ClassRangeTooLarge},
+		{"-32005 rate limit", codeError{-32005, "daily request count exceeded"}, ClassRateLimited},
+		{"http 429", httpError(http.StatusTooManyRequests, "slow down"), ClassRateLimited},
+		{"json-rpc code 429 is not an http status", codeError{http.StatusTooManyRequests, "internal error"}, ClassUnknown},
+		{"http 413", httpError(http.StatusRequestEntityTooLarge, ""), ClassBatchTooLarge},
+		{"geth batch limit", codeError{-32600, "batch too large"}, ClassBatchTooLarge},
+		{"reverted", codeError{3, "execution reverted: Ownable: caller is not the owner"}, ClassExecutionReverted},
+		{"http 401", httpError(http.StatusUnauthorized, ""), ClassAuth},
+		{"deadline", errors.Wrap(context.DeadlineExceeded, "eth_call"), ClassTimeout},
+		{"http 503", httpError(http.StatusServiceUnavailable, ""), ClassNetwork},
+		{"eof", fmt.Errorf("Post \"https://node.example\": %w", io.EOF), ClassNetwork},
+		{"unexpected eof message", errors.New("read response: unexpected EOF"), ClassNetwork},
+		{"eof letters in a word", errors.New("invalid geofence thereof"), ClassUnknown},
+		{"nonce too low", codeError{-32000, "nonce too low: next nonce 5, tx nonce 4"}, ClassNonceTooLow},
+		{"already known", codeError{-32000, "already known"}, ClassAlreadyKnown},
+		{"replacement underpriced", codeError{-32000, "replacement transaction underpriced"}, ClassUnderpriced},
+	} {
+		if got := Classify(test.err); got != test.want {
+			t.Errorf("%s: got class %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestErrorClassRetryable(t *testing.T) {
+	retryable := map[ErrorClass]bool{ClassRateLimited: true, ClassTimeout: true, ClassNetwork: true, ClassAuth: true, ClassNotSynced: true}
+	for class := range errorClassNames {
+		if class.Retryable() != retryable[class] {
+			t.Errorf("class %s is retryable %v, want %v", class, class.Retryable(), retryable[class])
+		}
+	}
+}
+
+func TestClassifyWithRules(t *testing.T) {
+	rules := []ErrorRule{{Class: ClassRateLimited, Codes: []int{-32099}}, {Class: ClassNotFound, Contains: []string{"Block Missing"}}}
+	for _, test := range []struct {
+		err  error
+		want ErrorClass
+	}{
+		{codeError{-32099, "credits exhausted"}, ClassRateLimited},
+		{codeError{-32000, "block missing from the store"}, ClassNotFound},
+		// the configured rules are matched before the built-in ones
+		{codeError{-32000, "header not found: block missing"}, ClassNotFound},
+		{codeError{-32000, "header not found"}, ClassNotSynced},
+	} {
+		if got := classify(test.err, rules); got != test.want {
+			t.Errorf("%v: got class %s, want %s", test.err, got, test.want)
+		}
+	}
+
+	var class ErrorClass
+	if err := class.UnmarshalText([]byte("NOT_SYNCED")); err != nil || class != ClassNotSynced {
+		t.Fatalf("got class %s (error %v), want not_synced", class, err)
+	}
+	if err := class.UnmarshalText([]byte("missing")); err == nil {
+		t.Fatal("decoded an unknown class name")
+	}
+}
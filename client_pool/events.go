@@ -0,0 +1,340 @@
+package client_pool
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+)
+
+// Standards of the built-in event signatures
+const (
+	StandardERC20     = "ERC-20"
+	StandardERC721    = "ERC-721"
+	StandardERC1155   = "ERC-1155"
+	StandardUniswapV2 = "UniswapV2"
+	StandardUniswapV3 = "UniswapV3"
+)
+
+type (
+	// Event is a log decoded with the signature of its event
+	Event struct {
+		// Standard is the standard or the ABI the event was registered with, like ERC-20 or UniswapV3
+		Standard string
+		Name     string
+		// Signature is the canonical signature, like Transfer(address,address,uint256)
+		Signature string
+		Address   common.Address
+		// Fields hold the inputs of the event by name, with the Go types of go-ethereum abi: common.Address,
+		// *big.Int for integers larger than 64 bits, []byte, ... Indexed inputs of dynamic types only
+		// hold the common.Hash of their value
+		Fields map[string]interface{}
+		Log    types.Log
+	}
+
+	// EventRegistry hold the event signatures used to decode logs. Events are found by their topic, events
+	// sharing a topic, like the ERC-20 and ERC-721 Transfer, are told apart by their number of indexed inputs
+	EventRegistry struct {
+		mu        sync.RWMutex
+		events    map[common.Hash][]registeredEvent
+		contracts map[common.Address]map[common.Hash][]registeredEvent
+	}
+
+	registeredEvent struct {
+		standard string
+		event    abi.Event
+	}
+)
+
+var (
+	// ErrUnknownEvent is returned by DecodeLog when no registered event match the log
+	ErrUnknownEvent = errors.New("unknown event")
+
+	// builtinEvents are registered in every new EventRegistry
+	builtinEvents = []struct {
+		standard   string
+		signatures []string
+	}{
+		{StandardERC20, []string{
+			"Transfer(address indexed from, address indexed to, uint256 value)",
+			"Approval(address indexed owner, address indexed spender, uint256 value)",
+		}},
+		{StandardERC721, []string{
+			"Transfer(address indexed from, address indexed to, uint256 indexed tokenId)",
+			"Approval(address indexed owner, address indexed approved, uint256 indexed tokenId)",
+			"ApprovalForAll(address indexed owner, address indexed operator, bool approved)",
+		}},
+		{StandardERC1155, []string{
+			"TransferSingle(address indexed operator, address indexed from, address indexed to, uint256 id, uint256 value)",
+			"TransferBatch(address indexed operator, address indexed from, address indexed to, uint256[] ids, uint256[] values)",
+		}},
+		{StandardUniswapV2, []string{
+			"Swap(address indexed sender, uint256 amount0In, uint256 amount1In, uint256 amount0Out, uint256 amount1Out, address indexed to)",
+			"Mint(address indexed sender, uint256 amount0, uint256 amount1)",
+			"Burn(address indexed sender, uint256 amount0, uint256 amount1, address indexed to)",
+			"Sync(uint112 reserve0, uint112 reserve1)",
+		}},
+		{StandardUniswapV3, []string{
+			"Swap(address indexed sender, address indexed recipient, int256 amount0, int256 amount1, uint160 sqrtPriceX96, uint128 liquidity, int24 tick)",
+			"Mint(address sender, address indexed owner, int24 indexed tickLower, int24 indexed tickUpper, uint128 amount, uint256 amount0, uint256 amount1)",
+			"Burn(address indexed owner, int24 indexed tickLower, int24 indexed tickUpper, uint128 amount, uint256 amount0, uint256 amount1)",
+		}},
+	}
+
+	defaultEvents     *EventRegistry
+	defaultEventsOnce sync.Once
+)
+
+// NewEventRegistry return a registry holding the built-in ERC-20, ERC-721, ERC-1155 and Uniswap V2/V3 events
+func NewEventRegistry() *EventRegistry {
+	r := &EventRegistry{
+		events:    make(map[common.Hash][]registeredEvent),
+		contracts: make(map[common.Address]map[common.Hash][]registeredEvent),
+	}
+	for _, builtin := range builtinEvents {
+		for _, signature := range builtin.signatures {
+			if err := r.RegisterEvent(builtin.standard, signature); err != nil {
+				panic(err)
+			}
+		}
+	}
+	return r
+}
+
+// DefaultEvents return the registry used by DecodeLogs, events registered in it are decoded everywhere
+func DefaultEvents() *EventRegistry {
+	defaultEventsOnce.Do(func() {
+		defaultEvents = NewEventRegistry()
+	})
+	return defaultEvents
+}
+
+// DecodeLogs decode the logs with DefaultEvents, see EventRegistry.DecodeLogs
+func DecodeLogs(logs []types.Log) ([]Event, error) {
+	return DefaultEvents().DecodeLogs(logs)
+}
+
+// RegisterEvent add an event given by its human-readable signature, like
+// "Transfer(address indexed from, address indexed to, uint256 value)". Input names are optional.
+// An event with the same topic and number of indexed inputs is replaced
+func (r *EventRegistry) RegisterEvent(standard, signature string) error {
+	event, err := parseEventSignature(signature)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events[event.ID] = withEvent(r.events[event.ID], registeredEvent{standard: standard, event: event})
+	return nil
+}
+
+// RegisterABI add the events of a contract JSON ABI, for the logs of every contract
+func (r *EventRegistry) RegisterABI(standard, abiJSON string) error {
+	events, err := abiEvents(standard, abiJSON)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, event := range events {
+		r.events[event.event.ID] = withEvent(r.events[event.event.ID], event)
+	}
+	return nil
+}
+
+// RegisterContractABI add the events of a contract JSON ABI for the logs of the contract only,
+// they are matched before the events registered for every contract
+func (r *EventRegistry) RegisterContractABI(address common.Address, standard, abiJSON string) error {
+	events, err := abiEvents(standard, abiJSON)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	contract := r.contracts[address]
+	if contract == nil {
+		contract = make(map[common.Hash][]registeredEvent)
+		r.contracts[address] = contract
+	}
+	for _, event := range events {
+		contract[event.event.ID] = withEvent(contract[event.event.ID], event)
+	}
+	return nil
+}
+
+// DecodeLogs decode the logs of registered events, in order. Logs of unknown events are skipped, the logs
+// that match an event but cannot be decoded with it are reported in a BatchError keyed by their index in logs,
+// returned along with the events of the other logs
+func (r *EventRegistry) DecodeLogs(logs []types.Log) ([]Event, error) {
+	events := make([]Event, 0, len(logs))
+	batchErr := BatchError[int]{}
+	for i, log := range logs {
+		event, err := r.DecodeLog(log)
+		if errors.Is(err, ErrUnknownEvent) {
+			continue
+		}
+		if err != nil {
+			batchErr[i] = err
+			continue
+		}
+		events = append(events, *event)
+	}
+	if len(batchErr) > 0 {
+		return events, batchErr
+	}
+	return events, nil
+}
+
+// DecodeLog decode a log, it fails with ErrUnknownEvent when no registered event match the log
+func (r *EventRegistry) DecodeLog(log types.Log) (*Event, error) {
+	registered, ok := r.lookup(log)
+	if !ok {
+		return nil, ErrUnknownEvent
+	}
+	fields := make(map[string]interface{}, len(registered.event.Inputs))
+	var indexed abi.Arguments
+	for _, input := range registered.event.Inputs {
+		if input.Indexed {
+			indexed = append(indexed, input)
+		}
+	}
+	if err := abi.ParseTopicsIntoMap(fields, indexed, log.Topics[1:]); err != nil {
+		return nil, errors.Wrapf(err, "unable to decode topics of %s log %d in tx %s",
+			registered.event.Sig, log.Index, log.TxHash)
+	}
+	if err := registered.event.Inputs.NonIndexed().UnpackIntoMap(fields, log.Data); err != nil {
+		return nil, errors.Wrapf(err, "unable to decode data of %s log %d in tx %s",
+			registered.event.Sig, log.Index, log.TxHash)
+	}
+	return &Event{
+		Standard:  registered.standard,
+		Name:      registered.event.RawName,
+		Signature: registered.event.Sig,
+		Address:   log.Address,
+		Fields:    fields,
+		Log:       log,
+	}, nil
+}
+
+// lookup return the event of the log, the events of its contract first
+func (r *EventRegistry) lookup(log types.Log) (registeredEvent, bool) {
+	if len(log.Topics) == 0 {
+		return registeredEvent{}, false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if event, ok := matchEvent(r.contracts[log.Address][log.Topics[0]], log); ok {
+		return event, true
+	}
+	return matchEvent(r.events[log.Topics[0]], log)
+}
+
+func matchEvent(candidates []registeredEvent, log types.Log) (registeredEvent, bool) {
+	for _, candidate := range candidates {
+		if indexedInputs(candidate.event) == len(log.Topics)-1 {
+			return candidate, true
+		}
+	}
+	return registeredEvent{}, false
+}
+
+// withEvent add the event to the events of its topic, replacing the one with the same number of indexed inputs
+func withEvent(events []registeredEvent, event registeredEvent) []registeredEvent {
+	for i, existing := range events {
+		if indexedInputs(existing.event) == indexedInputs(event.event) {
+			events[i] = event
+			return events
+		}
+	}
+	return append(events, event)
+}
+
+func indexedInputs(event abi.Event) int {
+	count := 0
+	for _, input := range event.Inputs {
+		if input.Indexed {
+			count++
+		}
+	}
+	return count
+}
+
+// abiEvents return the events of a JSON ABI, anonymous events have no topic to be found by and are left out
+func abiEvents(standard, abiJSON string) ([]registeredEvent, error) {
+	parsed, err := abi.JSON(strings.NewReader(abiJSON))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse abi")
+	}
+	events := make([]registeredEvent, 0, len(parsed.Events))
+	for _, event := range parsed.Events {
+		if !event.Anonymous {
+			events = append(events, registeredEvent{standard: standard, event: event})
+		}
+	}
+	return events, nil
+}
+
+// parseEventSignature parse a human-readable event signature, tuple inputs are not supported
+func parseEventSignature(signature string) (abi.Event, error) {
+	signature = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(signature), "event "))
+	open, end := strings.Index(signature, "("), strings.LastIndex(signature, ")")
+	if open <= 0 || end != len(signature)-1 {
+		return abi.Event{}, errors.Errorf("invalid event signature %q", signature)
+	}
+	name := strings.TrimSpace(signature[:open])
+	var inputs abi.Arguments
+	if params := strings.TrimSpace(signature[open+1 : end]); params != "" {
+		for _, param := range strings.Split(params, ",") {
+			words := strings.Fields(param)
+			if len(words) == 0 {
+				return abi.Event{}, errors.Errorf("empty input in event signature %q", signature)
+			}
+			abiType, err := abi.NewType(words[0], "", nil)
+			if err != nil {
+				return abi.Event{}, errors.Wrapf(err, "invalid input type in event signature %q", signature)
+			}
+			input := abi.Argument{Type: abiType}
+			words = words[1:]
+			if len(words) > 0 && words[0] == "indexed" {
+				input.Indexed = true
+				words = words[1:]
+			}
+			switch len(words) {
+			case 0:
+			case 1:
+				input.Name = words[0]
+			default:
+				return abi.Event{}, errors.Errorf("invalid input %q in event signature %q", param, signature)
+			}
+			inputs = append(inputs, input)
+		}
+	}
+	return abi.NewEvent(name, name, false, inputs), nil
+}
+
+// Unpack copy the fields into the struct pointed by out, matching the camel-cased field names,
+// like Unpack of go-ethereum abi. Fields without a struct field are left out
+func (e *Event) Unpack(out interface{}) error {
+	value := reflect.ValueOf(out)
+	if value.Kind() != reflect.Pointer || value.Elem().Kind() != reflect.Struct {
+		return errors.Errorf("unpack %s into %T, want a pointer to a struct", e.Name, out)
+	}
+	value = value.Elem()
+	for name, field := range e.Fields {
+		target := value.FieldByName(abi.ToCamelCase(name))
+		if !target.IsValid() || !target.CanSet() {
+			continue
+		}
+		fieldValue := reflect.ValueOf(field)
+		if !fieldValue.Type().AssignableTo(target.Type()) {
+			return errors.Errorf("unpack field %s of %s: %s is not assignable to %s",
+				name, e.Name, fieldValue.Type(), target.Type())
+		}
+		target.Set(fieldValue)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package client_pool_test
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/duongtuttbn/toolkit/client_pool"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestDecodeLogs(t *testing.T) {
+	uint256, _ := abi.NewType("uint256", "", nil)
+	int256, _ := abi.NewType("int256", "", nil)
+	uint160, _ := abi.NewType("uint160", "", nil)
+	uint128, _ := abi.NewType("uint128", "", nil)
+	int24, _ := abi.NewType("int24", "", nil)
+	pack := func(types []abi.Type, values ...interface{}) []byte {
+		arguments := make(abi.Arguments, len(types))
+		for i, typ := range types {
+			arguments[i] = abi.Argument{Type: typ}
+		}
+		data, err := arguments.Pack(values...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	from, to, pair := common.HexToAddress("0xa"), common.HexToAddress("0xb"), common.HexToAddress("0xc")
+	swapTopic := crypto.Keccak256Hash([]byte("Swap(address,address,int256,int256,uint160,uint128,int24)"))
+	customTopic := crypto.Keccak256Hash([]byte("Deposit(address,uint256)"))
+
+	logs := []types.Log{
+		// ERC-20 and ERC-721 Transfer share a topic, the token id of ERC-721 is indexed
+		{Address: pair, BlockNumber: 10, Topics: []common.Hash{transferTopic, common.BytesToHash(from.Bytes()), common.BytesToHash(to.Bytes())},
+			Data: pack([]abi.Type{uint256}, big.NewInt(1000))},
+		// a malformed ERC-20 Transfer, its value is truncated
+		{Address: pair, BlockNumber: 10, Index: 1, Topics: []common.Hash{transferTopic, common.BytesToHash(from.Bytes()), common.BytesToHash(to.Bytes())},
+			Data: []byte{0x03, 0xe8}},
+		{Address: pair, BlockNumber: 11, Topics: []common.Hash{transferTopic, common.BytesToHash(from.Bytes()), common.BytesToHash(to.Bytes()), common.BigToHash(big.NewInt(7))}},
+		{Address: pair, BlockNumber: 12, Topics: []common.Hash{swapTopic, common.BytesToHash(from.Bytes()), common.BytesToHash(to.Bytes())},
+			Data: pack([]abi.Type{int256, int256, uint160, uint128, int24}, big.NewInt(-500), big.NewInt(250), big.NewInt(1<<40), big.NewInt(1e6), big.NewInt(-10))},
+		{Address: pair, BlockNumber: 13, Topics: []common.Hash{customTopic, common.BytesToHash(from.Bytes())},
+			Data: pack([]abi.Type{uint256}, big.NewInt(3))},
+	}
+
+	registry := client_pool.NewEventRegistry()
+	// logs of unregistered events are skipped, the malformed one is reported along with the other events
+	events, err := registry.DecodeLogs(logs)
+	var batchErr client_pool.BatchError[int]
+	if !errors.As(err, &batchErr) || len(batchErr) != 1 || batchErr[1] == nil {
+		t.Fatalf("got error %v, want the malformed log reported", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want the 3 built-in ones", len(events))
+	}
+	if events[0].Standard != client_pool.StandardERC20 || events[0].Fields["value"].(*big.Int).Int64() != 1000 ||
+		events[0].Fields["from"].(common.Address) != from {
+		t.Fatalf("unexpected ERC-20 transfer: %+v", events[0])
+	}
+	if events[1].Standard != client_pool.StandardERC721 || events[1].Fields["tokenId"].(*big.Int).Int64() != 7 {
+		t.Fatalf("unexpected ERC-721 transfer: %+v", events[1])
+	}
+	var swap struct {
+		Sender, Recipient common.Address
+		Amount0, Amount1  *big.Int
+		Tick              *big.Int
+	}
+	if err := events[2].Unpack(&swap); err != nil {
+		t.Fatal(err)
+	}
+	if events[2].Standard != client_pool.StandardUniswapV3 || swap.Recipient != to || swap.Amount0.Int64() != -500 || swap.Tick.Int64() != -10 {
+		t.Fatalf("unexpected Uniswap V3 swap: %+v %+v", events[2], swap)
+	}
+
+	if err := registry.RegisterEvent("WETH", "Deposit(address indexed dst, uint256 wad)"); err != nil {
+		t.Fatal(err)
+	}
+	deposit, err := registry.DecodeLog(logs[4])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deposit.Signature != "Deposit(address,uint256)" || deposit.Fields["dst"].(common.Address) != from || deposit.Fields["wad"].(*big.Int).Int64() != 3 {
+		t.Fatalf("unexpected deposit: %+v", deposit)
+	}
+}
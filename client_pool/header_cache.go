@@ -0,0 +1,123 @@
+package client_pool
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type (
+	HeaderCacheConfig struct {
+		// Size is the number of block headers kept, default to 10000. A negative size disable the cache
+		Size int `json:"size" yaml:"size"`
+		// TTL is how long a final header is kept, zero keeps it until it is evicted
+		TTL time.Duration `json:"ttl" yaml:"ttl"`
+		// FinalityDepth is how many blocks behind the head a block must be to be considered final, default to 64
+		FinalityDepth uint64 `json:"finality_depth" yaml:"finality_depth"`
+		// RecentTTL is how long the header of a block that is not final yet is kept, as it may be reorged,
+		// default to 15 seconds. Every header is recent until the pool fetched the head once
+		RecentTTL time.Duration `json:"recent_ttl" yaml:"recent_ttl"`
+	}
+
+	// headerCache is an LRU cache of the block header fields used by the pool, keyed by block number
+	headerCache struct {
+		mu            sync.Mutex
+		size          int
+		ttl           time.Duration
+		finalityDepth uint64
+		recentTTL     time.Duration
+		// head is the highest block number the pool has seen as the head of the chain
+		head    uint64
+		order   *list.List
+		entries map[uint64]*list.Element
+	}
+
+	cachedHeader struct {
+		number    uint64
+		time      uint64
+		expiresAt time.Time
+	}
+)
+
+const (
+	defaultHeaderCacheSize = 10000
+	defaultFinalityDepth   = 64
+	defaultRecentHeaderTTL = 15 * time.Second
+)
+
+func newHeaderCache(cfg HeaderCacheConfig) *headerCache {
+	size := cfg.Size
+	if size == 0 {
+		size = defaultHeaderCacheSize
+	}
+	finalityDepth := cfg.FinalityDepth
+	if finalityDepth == 0 {
+		finalityDepth = defaultFinalityDepth
+	}
+	recentTTL := cfg.RecentTTL
+	if recentTTL <= 0 {
+		recentTTL = defaultRecentHeaderTTL
+	}
+	return &headerCache{
+		size:          size,
+		ttl:           cfg.TTL,
+		finalityDepth: finalityDepth,
+		recentTTL:     recentTTL,
+		order:         list.New(),
+		entries:       map[uint64]*list.Element{},
+	}
+}
+
+// observeHead record a head of the chain, the blocks far enough behind the highest one are final
+func (c *headerCache) observeHead(head uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if head > c.head {
+		c.head = head
+	}
+}
+
+// blockTime return the cached timestamp of the block, if it is cached and not expired
+func (c *headerCache) blockTime(blockNumber uint64) (uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[blockNumber]
+	if !ok {
+		return 0, false
+	}
+	header := element.Value.(*cachedHeader)
+	if !header.expiresAt.IsZero() && time.Now().After(header.expiresAt) {
+		c.order.Remove(element)
+		delete(c.entries, blockNumber)
+		return 0, false
+	}
+	c.order.MoveToFront(element)
+	return header.time, true
+}
+
+func (c *headerCache) add(blockNumber, blockTime uint64) {
+	if c.size < 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	header := &cachedHeader{number: blockNumber, time: blockTime}
+	ttl := c.ttl
+	if recent := c.head == 0 || blockNumber+c.finalityDepth > c.head; recent && (ttl == 0 || c.recentTTL < ttl) {
+		ttl = c.recentTTL
+	}
+	if ttl > 0 {
+		header.expiresAt = time.Now().Add(ttl)
+	}
+	if element, ok := c.entries[blockNumber]; ok {
+		element.Value = header
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[blockNumber] = c.order.PushFront(header)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedHeader).number)
+	}
+}
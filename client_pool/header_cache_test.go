@@ -0,0 +1,67 @@
+package client_pool
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHeaderCacheKeepsFinalHeaders(t *testing.T) {
+	cache := newHeaderCache(HeaderCacheConfig{FinalityDepth: 10, RecentTTL: 20 * time.Millisecond})
+	// until the head is known, no header can be told final
+	cache.add(1, 100)
+	cache.observeHead(50)
+	cache.add(2, 200)
+	cache.add(45, 4500)
+	cache.add(60, 6000)
+	for block, want := range map[uint64]uint64{1: 100, 2: 200, 45: 4500, 60: 6000} {
+		if got, ok := cache.blockTime(block); !ok || got != want {
+			t.Fatalf("got time %d of block %d (cached %v), want %d", got, block, ok, want)
+		}
+	}
+
+	// the recent headers, and the ones cached before the head was known, may be reorged and expire
+	time.Sleep(40 * time.Millisecond)
+	for block, final := range map[uint64]bool{1: false, 2: true, 45: false, 60: false} {
+		if _, ok := cache.blockTime(block); ok != final {
+			t.Fatalf("block %d is cached %v after the recent TTL, want %v", block, ok, final)
+		}
+	}
+
+	// a lower head does not make final blocks recent again
+	cache.observeHead(20)
+	cache.add(35, 3500)
+	time.Sleep(40 * time.Millisecond)
+	if _, ok := cache.blockTime(35); !ok {
+		t.Fatal("block 35 expired, want it final behind head 50")
+	}
+}
+
+func TestHeaderCacheTTLAndEviction(t *testing.T) {
+	cache := newHeaderCache(HeaderCacheConfig{Size: 2, TTL: 20 * time.Millisecond, RecentTTL: time.Hour})
+	cache.observeHead(1000)
+	cache.add(1, 100)
+	cache.add(2, 200)
+	// reading block 1 make block 2 the least recently used one
+	if _, ok := cache.blockTime(1); !ok {
+		t.Fatal("block 1 is not cached")
+	}
+	cache.add(3, 300)
+	if _, ok := cache.blockTime(2); ok {
+		t.Fatal("block 2 is still cached, want it evicted")
+	}
+	// the TTL of final headers also bound the recent ones
+	cache.add(999, 99900)
+	time.Sleep(40 * time.Millisecond)
+	for _, block := range []uint64{1, 3, 999} {
+		if _, ok := cache.blockTime(block); ok {
+			t.Fatalf("block %d is still cached after the TTL", block)
+		}
+	}
+
+	disabled := newHeaderCache(HeaderCacheConfig{Size: -1})
+	disabled.observeHead(1000)
+	disabled.add(1, 100)
+	if _, ok := disabled.blockTime(1); ok {
+		t.Fatal("disabled cache kept a header")
+	}
+}
@@ -0,0 +1,165 @@
+package client_pool
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/duongtuttbn/toolkit/log"
+)
+
+type (
+	HealthCheckConfig struct {
+		// Interval between two rounds of checks, default to 30 seconds
+		Interval time.Duration
+		// Timeout of a single BlockNumber request, default to 10 seconds
+		Timeout time.Duration
+		// MaxBlockLag is how many blocks a client may be behind the best head
+		// before it is marked unavailable, default to 5 blocks
+		MaxBlockLag uint64
+	}
+
+	// HealthChecker periodically poll every client of a pool with BlockNumber and take
+	// the clients lagging behind the best head out of rotation
+	HealthChecker struct {
+		pool   *ClientPool
+		config HealthCheckConfig
+		mu     sync.Mutex
+		heads  map[string]uint64
+		cancel context.CancelFunc
+		done   chan struct{}
+	}
+)
+
+const (
+	defaultHealthCheckInterval = 30 * time.Second
+	defaultHealthCheckTimeout  = 10 * time.Second
+	defaultMaxBlockLag         = 5
+)
+
+func NewHealthChecker(pool *ClientPool, cfg HealthCheckConfig) *HealthChecker {
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultHealthCheckInterval
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultHealthCheckTimeout
+	}
+	if cfg.MaxBlockLag == 0 {
+		cfg.MaxBlockLag = defaultMaxBlockLag
+	}
+	return &HealthChecker{
+		pool:   pool,
+		config: cfg,
+		heads:  make(map[string]uint64),
+	}
+}
+
+// Start run the checker in background until Stop is called or ctx is done.
+// Calling Start on a running checker does nothing
+func (h *HealthChecker) Start(ctx context.Context) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.cancel != nil {
+		return
+	}
+	ctx, h.cancel = context.WithCancel(ctx)
+	h.done = make(chan struct{})
+	go h.run(ctx, h.done)
+}
+
+// Stop the background checker, wait for it to exit and put lagging clients back into rotation
+func (h *HealthChecker) Stop() {
+	h.mu.Lock()
+	cancel, done := h.cancel, h.done
+	h.cancel, h.done = nil, nil
+	h.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+	for _, client := range h.pool.GetAllClients() {
+		client.setLagging(false)
+	}
+}
+
+// Heads return the last head observed on each endpoint, keyed by client label
+func (h *HealthChecker) Heads() map[string]uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	heads := make(map[string]uint64, len(h.heads))
+	for endpoint, head := range h.heads {
+		heads[endpoint] = head
+	}
+	return heads
+}
+
+func (h *HealthChecker) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(h.config.Interval)
+	defer ticker.Stop()
+	for {
+		h.check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check poll every client whose circuit is closed, then mark the ones too far behind the best head as lagging.
+// Open circuits are left to their own probe
+func (h *HealthChecker) check(ctx context.Context) {
+	clients := h.pool.GetAllClients()
+	heads := make([]uint64, len(clients))
+	polled := make([]bool, len(clients))
+	var wg sync.WaitGroup
+	for i, client := range clients {
+		if client.State() != CircuitClosed || client.Quarantined() {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, client *Client) {
+			defer wg.Done()
+			reqCtx, cancel := context.WithTimeout(ctx, h.config.Timeout)
+			defer cancel()
+			head, err := call(withMethod(reqCtx, "eth_blockNumber"), client, client.BlockNumber)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.FromContext(ctx).Errorf("health check endpoint %s error: %v", client.Label(), err)
+					client.MarkError(err)
+				}
+				return
+			}
+			heads[i] = head
+			polled[i] = true
+		}(i, client)
+	}
+	wg.Wait()
+
+	var best uint64
+	for i := range clients {
+		if polled[i] && heads[i] > best {
+			best = heads[i]
+		}
+	}
+	h.mu.Lock()
+	for i, client := range clients {
+		if polled[i] {
+			h.heads[client.Label()] = heads[i]
+		}
+	}
+	h.mu.Unlock()
+	for i, client := range clients {
+		if !polled[i] {
+			continue
+		}
+		lag := best - heads[i]
+		lagging := lag > h.config.MaxBlockLag
+		if lagging {
+			log.FromContext(ctx).Infof("endpoint %s is %d blocks behind head %d, take it out of rotation", client.Label(), lag, best)
+		}
+		client.setLagging(lagging)
+	}
+}
@@ -0,0 +1,71 @@
+package client_pool_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/duongtuttbn/toolkit/client_pool"
+)
+
+func TestHealthCheckerMarksLaggingEndpoint(t *testing.T) {
+	f := newFixture(t, 100, 2)
+	healthy, lagging := f.servers[0], f.servers[1]
+	lagging.SetHeadLag(10)
+	pool := f.pool(t, client_pool.Config{})
+	checker := client_pool.NewHealthChecker(pool, client_pool.HealthCheckConfig{Interval: 10 * time.Millisecond, MaxBlockLag: 5})
+	checker.Start(f.ctx)
+	defer checker.Stop()
+
+	for len(checker.Heads()) < 2 && f.ctx.Err() == nil {
+		time.Sleep(5 * time.Millisecond)
+	}
+	heads := checker.Heads()
+	if heads[healthy.URL] != 100 || heads[lagging.URL] != 90 {
+		t.Fatalf("got heads %v, want 100 for %s and 90 for %s", heads, healthy.URL, lagging.URL)
+	}
+	clients := pool.GetAllClients()
+	if !clients[0].IsAvailable() || clients[1].IsAvailable() {
+		t.Fatal("the endpoint 10 blocks behind is still in rotation")
+	}
+
+	// the endpoint catch up, the next round put it back into rotation
+	lagging.SetHeadLag(2)
+	for !clients[1].IsAvailable() && f.ctx.Err() == nil {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if heads := checker.Heads(); heads[lagging.URL] != 98 {
+		t.Fatalf("got head %d for the endpoint that caught up, want 98", heads[lagging.URL])
+	}
+
+	lagging.SetHeadLag(10)
+	for clients[1].IsAvailable() && f.ctx.Err() == nil {
+		time.Sleep(5 * time.Millisecond)
+	}
+	checker.Stop()
+	if !clients[1].IsAvailable() {
+		t.Fatal("lagging endpoint is still out of rotation after Stop")
+	}
+}
+
+func TestHealthCheckerHeadsOfSharedLabels(t *testing.T) {
+	f := newFixture(t, 100, 2)
+	f.servers[1].SetHeadLag(10)
+	// two API keys of the same provider share a label
+	pool, err := client_pool.NewBasicClientPool(client_pool.Config{Endpoints: []client_pool.EndpointConfig{
+		{URL: f.servers[0].URL, Label: "provider"},
+		{URL: f.servers[1].URL, Label: "provider"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checker := client_pool.NewHealthChecker(pool, client_pool.HealthCheckConfig{Interval: 10 * time.Millisecond, MaxBlockLag: 5})
+	checker.Start(f.ctx)
+	defer checker.Stop()
+
+	for len(checker.Heads()) < 2 && f.ctx.Err() == nil {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if heads := checker.Heads(); heads["provider#0"] != 100 || heads["provider#1"] != 90 {
+		t.Fatalf("got heads %v, want 100 for provider#0 and 90 for provider#1", heads)
+	}
+}
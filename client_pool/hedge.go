@@ -0,0 +1,196 @@
+package client_pool
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/duongtuttbn/toolkit/log"
+)
+
+type (
+	HedgeConfig struct {
+		// Enabled turn on hedging for GetLatestBlock, BlockTime and GetTransactionReceipt
+		Enabled bool `json:"enabled" yaml:"enabled"`
+		// Percentile of the recent latencies of an operation after which the hedge request is sent,
+		// default to 0.95
+		Percentile float64 `json:"percentile" yaml:"percentile"`
+		// Delay after which the hedge request is sent until enough latencies are observed,
+		// default to 500ms
+		Delay time.Duration `json:"delay" yaml:"delay"`
+	}
+
+	// latencyWindow keep the latest successful latencies of an operation
+	latencyWindow struct {
+		samples []time.Duration
+		next    int
+	}
+
+	hedgeOutcome[T any] struct {
+		result  T
+		err     error
+		client  *Client
+		latency time.Duration
+	}
+)
+
+const (
+	defaultHedgePercentile = 0.95
+	defaultHedgeDelay      = 500 * time.Millisecond
+	latencyWindowSize      = 128
+	// minLatencySamples is the number of samples needed before the percentile is trusted
+	minLatencySamples = 16
+)
+
+// hedged retry fn until it succeeds, ctx is done or it returns an error rejected by retryable.
+// Each try is sent to one client and, if it has not answered within the hedge delay, to a second one.
+// The first successful answer wins and the other request is cancelled
+func hedged[T any](
+	ctx context.Context,
+	pool *ClientPool,
+	op string,
+	retryable func(error) bool,
+	fn func(ctx context.Context, client *Client) (T, error),
+) (T, error) {
+	for {
+		client, err := pool.GetClientContext(ctx)
+		if err != nil {
+			var zero T
+			return zero, err
+		}
+		result, done, err := race(ctx, pool, op, client, retryable, fn)
+		if done {
+			return result, err
+		}
+	}
+}
+
+// race run fn on the primary client, then on a second available client after the hedge delay
+// or as soon as the primary fails. done is false when every attempt failed with a retryable error
+func race[T any](
+	ctx context.Context,
+	pool *ClientPool,
+	op string,
+	primary *Client,
+	retryable func(error) bool,
+	fn func(ctx context.Context, client *Client) (T, error),
+) (result T, done bool, err error) {
+	raceCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	outcomes := make(chan hedgeOutcome[T], 2)
+	launch := func(client *Client) {
+		go func() {
+			start := time.Now()
+			result, err := call(raceCtx, client, func(ctx context.Context) (T, error) {
+				return fn(ctx, client)
+			})
+			outcomes <- hedgeOutcome[T]{result: result, err: err, client: client, latency: time.Since(start)}
+		}()
+	}
+	// hedge send the request to a second client, if there is one available
+	hedged := false
+	pending := 0
+	hedge := func() {
+		hedged = true
+		clients, _, _ := pool.nextClients(1, primary)
+		if clients == nil {
+			return
+		}
+		log.FromContext(ctx).Debugf("%s on endpoint %s is slow or failed, hedge on endpoint %s", op, primary.Label(), clients[0].Label())
+		launch(clients[0])
+		pending++
+	}
+
+	launch(primary)
+	pending++
+	timer := time.NewTimer(pool.hedgeDelay(op))
+	defer timer.Stop()
+	var fatalErr error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if !hedged {
+				hedge()
+			}
+		case outcome := <-outcomes:
+			pending--
+			if outcome.err == nil {
+				outcome.client.MarkSuccess()
+				pool.observeHedgeLatency(op, outcome.latency)
+				return outcome.result, true, nil
+			}
+			if ctx.Err() != nil {
+				return result, true, ctx.Err()
+			}
+			if !retryable(outcome.err) {
+				fatalErr = outcome.err
+				continue
+			}
+			outcome.client.MarkError(outcome.err)
+			log.FromContext(ctx).Errorf("%s on endpoint %s error: %v", op, outcome.client.Label(), outcome.err)
+			if !hedged {
+				hedge()
+			}
+		}
+	}
+	if fatalErr != nil {
+		return result, true, fatalErr
+	}
+	return result, false, nil
+}
+
+// hedgeDelay return how long to wait for the first answer of op before hedging
+func (pool *ClientPool) hedgeDelay(op string) time.Duration {
+	percentile := pool.config.Hedge.Percentile
+	if percentile <= 0 || percentile > 1 {
+		percentile = defaultHedgePercentile
+	}
+	delay := pool.config.Hedge.Delay
+	if delay <= 0 {
+		delay = defaultHedgeDelay
+	}
+	pool.hedgeMu.Lock()
+	defer pool.hedgeMu.Unlock()
+	window := pool.hedgeLatencies[op]
+	if window == nil || len(window.samples) < minLatencySamples {
+		return delay
+	}
+	return window.percentile(percentile)
+}
+
+func (pool *ClientPool) observeHedgeLatency(op string, latency time.Duration) {
+	pool.hedgeMu.Lock()
+	defer pool.hedgeMu.Unlock()
+	if pool.hedgeLatencies == nil {
+		pool.hedgeLatencies = make(map[string]*latencyWindow)
+	}
+	window := pool.hedgeLatencies[op]
+	if window == nil {
+		window = &latencyWindow{}
+		pool.hedgeLatencies[op] = window
+	}
+	window.add(latency)
+}
+
+func (w *latencyWindow) add(latency time.Duration) {
+	if len(w.samples) < latencyWindowSize {
+		w.samples = append(w.samples, latency)
+		return
+	}
+	w.samples[w.next] = latency
+	w.next = (w.next + 1) % latencyWindowSize
+}
+
+func (w *latencyWindow) percentile(p float64) time.Duration {
+	sorted := make([]time.Duration, len(w.samples))
+	copy(sorted, w.samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	index := int(p*float64(len(sorted))+0.5) - 1
+	if index < 0 {
+		index = 0
+	}
+	if index >= len(sorted) {
+		index = len(sorted) - 1
+	}
+	return sorted[index]
+}
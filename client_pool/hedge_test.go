@@ -0,0 +1,44 @@
+package client_pool_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/duongtuttbn/toolkit/client_pool"
+)
+
+func TestHedgeBeatsSlowPrimary(t *testing.T) {
+	f := newFixture(t, 100, 2)
+	slow, fast := f.servers[0], f.servers[1]
+	// the configured delay is only used until enough latencies are observed, it would make the test time out
+	pool := f.pool(t, client_pool.Config{Hedge: client_pool.HedgeConfig{Enabled: true, Percentile: 0.9, Delay: 10 * time.Second}})
+	// the warm-up stop at the 16 latencies the percentile needs, so none of its requests wait less than
+	// the configured delay and get hedged to reach an endpoint once the test runs
+	for i := 0; i < 16; i++ {
+		if _, err := pool.GetLatestBlockContext(f.ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	slow.SetLatency(2 * time.Second)
+	for i := 0; i < 4; i++ {
+		start := time.Now()
+		block, err := pool.GetLatestBlockContext(f.ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block != 100 {
+			t.Fatalf("got block %d, want 100", block)
+		}
+		// the hedge is sent after the 90th percentile of the fast warm-up latencies, a few milliseconds
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Fatalf("request %d took %v, want the hedge to answer before the slow primary", i, elapsed)
+		}
+	}
+	if requests := fast.Requests("eth_blockNumber"); requests != 12 {
+		t.Fatalf("fast endpoint answered %d requests, want its 8 warm-up ones and all 4", requests)
+	}
+	if requests := slow.Requests("eth_blockNumber"); requests != 8 {
+		t.Fatalf("slow endpoint answered %d requests, want only its 8 warm-up ones", requests)
+	}
+}
@@ -0,0 +1,296 @@
+package client_pool
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/duongtuttbn/toolkit/log"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+)
+
+type (
+	LogRangeConfig struct {
+		// InitialWindow is the number of blocks first requested from an endpoint in one eth_getLogs,
+		// default to 2000. EndpointConfig.LogWindow override it per endpoint
+		InitialWindow uint64 `json:"initial_window" yaml:"initial_window"`
+		// MinWindow is the smallest window an endpoint is shrunk to, default to 1
+		MinWindow uint64 `json:"min_window" yaml:"min_window"`
+		// MaxWindow is the largest window an endpoint is grown to, default to 10000
+		MaxWindow uint64 `json:"max_window" yaml:"max_window"`
+		// Concurrency is the number of chunks fetched in parallel, default to 4
+		Concurrency int `json:"concurrency" yaml:"concurrency"`
+	}
+
+	blockRange struct {
+		from uint64
+		to   uint64
+	}
+
+	logChunk struct {
+		blockRange
+		client *Client
+		logs   []types.Log
+		err    error
+	}
+
+	// logRangePlanner hand out the block ranges that are left to fetch, sized for the endpoint that will fetch them
+	logRangePlanner struct {
+		cursor    uint64
+		toBlock   uint64
+		exhausted bool
+		// pending is the ranges that failed and must be fetched again
+		pending []blockRange
+	}
+)
+
+const (
+	defaultLogWindow      = 2000
+	defaultMinLogWindow   = 1
+	defaultMaxLogWindow   = 10000
+	defaultLogConcurrency = 4
+)
+
+// suggestedRange match the block range suggested by infura and alchemy in their "too many results" errors
+var suggestedRange = regexp.MustCompile(`\[(0x[0-9a-fA-F]+),\s*(0x[0-9a-fA-F]+)\]`)
+
+// getLogs fetch the logs of [fromBlock, toBlock] in chunks sized for each endpoint, in parallel.
+// Chunks rejected as too large are split and the endpoint window is shrunk, chunks that failed with
+// a retryable error are retried and any other error is returned
+func (pool *ClientPool) getLogs(
+	ctx context.Context,
+	filterQuery ethereum.FilterQuery,
+	fromBlock, toBlock uint64,
+) ([]types.Log, error) {
+	if fromBlock > toBlock {
+		return []types.Log{}, nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	concurrency := pool.config.LogRange.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultLogConcurrency
+	}
+	planner := &logRangePlanner{cursor: fromBlock, toBlock: toBlock}
+	results := make(chan logChunk, concurrency)
+	chunks := make([]logChunk, 0)
+	inFlight := 0
+	for planner.hasWork() || inFlight > 0 {
+		if inFlight < concurrency && planner.hasWork() {
+			client, err := pool.GetClientContext(ctx)
+			if err != nil {
+				return nil, err
+			}
+			r := planner.next(pool.logWindow(client))
+			inFlight++
+			go func() {
+				logs, err := pool.fetchLogs(ctx, client, filterQuery, r.from, r.to)
+				results <- logChunk{blockRange: r, client: client, logs: logs, err: err}
+			}()
+			continue
+		}
+
+		var chunk logChunk
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case chunk = <-results:
+			inFlight--
+		}
+		if chunk.err == nil {
+			chunk.client.MarkSuccess()
+			pool.growLogWindow(chunk.client, chunk.size())
+			chunks = append(chunks, chunk)
+			continue
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if pool.Classify(chunk.err) == ClassRangeTooLarge {
+			if chunk.from == chunk.to {
+				return nil, errors.Wrapf(chunk.err, "logs of block %d are too large for endpoint %s", chunk.from, chunk.client.Label())
+			}
+			window := pool.shrinkLogWindow(chunk.client, chunk.size(), chunk.err)
+			log.FromContext(ctx).Infof(
+				"Logs [%d to %d] too large on endpoint %v, shrink its window to %d blocks",
+				chunk.from,
+				chunk.to,
+				chunk.client.Label(),
+				window,
+			)
+			planner.retry(chunk.blockRange)
+			continue
+		}
+		if !pool.retryable(chunk.err) {
+			return nil, errors.Wrapf(chunk.err, "fetch logs [%d to %d] on endpoint %s failed", chunk.from, chunk.to, chunk.client.Label())
+		}
+		chunk.client.MarkError(chunk.err)
+		log.FromContext(ctx).Errorf("Fetch logs [%d to %d] on endpoint %v error: %v", chunk.from, chunk.to, chunk.client.Label(), chunk.err)
+		planner.retry(chunk.blockRange)
+	}
+
+	return joinLogChunks(chunks), nil
+}
+
+// getClientLogs fetch the logs of [fromBlock, toBlock] from a single endpoint, one window after another.
+// Chunks rejected as too large are split and the endpoint window is shrunk, any other error is returned
+// so the caller can decide whether the endpoint failed
+func (pool *ClientPool) getClientLogs(
+	ctx context.Context,
+	client *Client,
+	filterQuery ethereum.FilterQuery,
+	fromBlock, toBlock uint64,
+) ([]types.Log, error) {
+	if fromBlock > toBlock {
+		return []types.Log{}, nil
+	}
+	planner := &logRangePlanner{cursor: fromBlock, toBlock: toBlock}
+	chunks := make([]logChunk, 0)
+	for planner.hasWork() {
+		r := planner.next(pool.logWindow(client))
+		logs, err := pool.fetchLogs(ctx, client, filterQuery, r.from, r.to)
+		if err == nil {
+			pool.growLogWindow(client, r.size())
+			chunks = append(chunks, logChunk{blockRange: r, client: client, logs: logs})
+			continue
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if pool.Classify(err) != ClassRangeTooLarge {
+			return nil, err
+		}
+		if r.from == r.to {
+			return nil, errors.Wrapf(err, "logs of block %d are too large for endpoint %s", r.from, client.Label())
+		}
+		window := pool.shrinkLogWindow(client, r.size(), err)
+		log.FromContext(ctx).Infof(
+			"Logs [%d to %d] too large on endpoint %v, shrink its window to %d blocks",
+			r.from,
+			r.to,
+			client.Label(),
+			window,
+		)
+		planner.retry(r)
+	}
+	return joinLogChunks(chunks), nil
+}
+
+// joinLogChunks return the logs of the chunks in block order
+func joinLogChunks(chunks []logChunk) []types.Log {
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].from < chunks[j].from })
+	logs := make([]types.Log, 0)
+	for _, chunk := range chunks {
+		logs = append(logs, chunk.logs...)
+	}
+	return logs
+}
+
+// logWindow return the number of blocks the client is currently asked for in one eth_getLogs
+func (pool *ClientPool) logWindow(client *Client) uint64 {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.logWindow == 0 {
+		client.logWindow = pool.config.LogRange.InitialWindow
+		if client.logWindow == 0 {
+			client.logWindow = defaultLogWindow
+		}
+	}
+	return client.logWindow
+}
+
+// shrinkLogWindow lower the client window below the size of a range it rejected,
+// to the range suggested by the endpoint if any, and return the new window
+func (pool *ClientPool) shrinkLogWindow(client *Client, size uint64, err error) uint64 {
+	window := size / 2
+	if suggested, ok := suggestedLogWindow(err); ok && suggested < size {
+		window = suggested
+	}
+	minWindow := pool.config.LogRange.MinWindow
+	if minWindow == 0 {
+		minWindow = defaultMinLogWindow
+	}
+	if window < minWindow {
+		window = minWindow
+	}
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.logWindow == 0 || window < client.logWindow {
+		client.logWindow = window
+	}
+	return client.logWindow
+}
+
+// growLogWindow slowly raise the client window after it served a full window
+func (pool *ClientPool) growLogWindow(client *Client, size uint64) {
+	maxWindow := pool.config.LogRange.MaxWindow
+	if maxWindow == 0 {
+		maxWindow = defaultMaxLogWindow
+	}
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if size < client.logWindow || client.logWindow >= maxWindow {
+		return
+	}
+	client.logWindow += client.logWindow/4 + 1
+	if client.logWindow > maxWindow {
+		client.logWindow = maxWindow
+	}
+}
+
+// suggestedLogWindow parse the block range an endpoint suggested in its error, if any
+func suggestedLogWindow(err error) (uint64, bool) {
+	match := suggestedRange.FindStringSubmatch(err.Error())
+	if match == nil {
+		return 0, false
+	}
+	from, err1 := strconv.ParseUint(hexaNumberToString(match[1]), 16, 64)
+	to, err2 := strconv.ParseUint(hexaNumberToString(match[2]), 16, 64)
+	if err1 != nil || err2 != nil || to < from {
+		return 0, false
+	}
+	return to - from + 1, true
+}
+
+func (r blockRange) size() uint64 {
+	return r.to - r.from + 1
+}
+
+func (p *logRangePlanner) hasWork() bool {
+	return len(p.pending) > 0 || !p.exhausted
+}
+
+// next return the next range to fetch, at most window blocks long
+func (p *logRangePlanner) next(window uint64) blockRange {
+	if window == 0 {
+		window = 1
+	}
+	if len(p.pending) > 0 {
+		r := p.pending[len(p.pending)-1]
+		p.pending = p.pending[:len(p.pending)-1]
+		if r.size() > window {
+			p.pending = append(p.pending, blockRange{from: r.from + window, to: r.to})
+			r.to = r.from + window - 1
+		}
+		return r
+	}
+	r := blockRange{from: p.cursor, to: p.toBlock}
+	if p.toBlock-p.cursor >= window {
+		r.to = p.cursor + window - 1
+	}
+	if r.to == p.toBlock {
+		p.exhausted = true
+	} else {
+		p.cursor = r.to + 1
+	}
+	return r
+}
+
+// retry put back a range that must be fetched again
+func (p *logRangePlanner) retry(r blockRange) {
+	p.pending = append(p.pending, r)
+}
@@ -0,0 +1,191 @@
+package client_pool
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/duongtuttbn/toolkit/log"
+)
+
+type (
+	// PoolStats is a snapshot of the state and counters of every client of a pool
+	PoolStats struct {
+		Clients []ClientStats `json:"clients"`
+		// Available is the number of clients currently serving requests
+		Available int `json:"available"`
+	}
+
+	// ClientStats is a snapshot of the state and counters of a client
+	ClientStats struct {
+		Label    string `json:"label"`
+		Endpoint string `json:"endpoint"`
+		State    string `json:"state"`
+		// Available is false when the circuit is not closed, the client is lagging or out of rate limit budget
+		Available bool `json:"available"`
+		Lagging   bool `json:"lagging"`
+		// Quarantined is set when the endpoint serve another chain than the pool
+		Quarantined bool `json:"quarantined"`
+		// ChainID is the chain served by the endpoint, zero until it is checked
+		ChainID uint64 `json:"chain_id"`
+		// AvailableAt is when an open circuit is half-opened, nil when the circuit is closed
+		AvailableAt *time.Time `json:"available_at,omitempty"`
+		LastError   string     `json:"last_error,omitempty"`
+		InFlight    int64      `json:"in_flight"`
+		// Requests is the number of requests sent through the pool helpers
+		Requests uint64 `json:"requests"`
+		// Errors is the number of failed requests by error class
+		Errors map[string]uint64 `json:"errors"`
+		// Benched is the number of times the circuit was opened
+		Benched uint64       `json:"benched"`
+		Latency LatencyStats `json:"latency"`
+	}
+
+	// LatencyStats is a cumulative histogram of the request durations
+	LatencyStats struct {
+		// EWMA is the exponentially weighted moving average used by the latency selector
+		EWMA    time.Duration   `json:"ewma"`
+		Count   uint64          `json:"count"`
+		Sum     time.Duration   `json:"sum"`
+		Buckets []LatencyBucket `json:"buckets"`
+	}
+
+	LatencyBucket struct {
+		UpperBound time.Duration `json:"upper_bound"`
+		// Count is the number of requests that took at most UpperBound
+		Count uint64 `json:"count"`
+	}
+
+	clientMetrics struct {
+		mu       sync.Mutex
+		requests uint64
+		errors   map[ErrorClass]uint64
+		benched  uint64
+		count    uint64
+		sum      time.Duration
+		// buckets count the requests per bucket of latencyBuckets, the last one is +Inf
+		buckets []uint64
+	}
+)
+
+// latencyBuckets are the upper bounds of the latency histogram buckets
+var latencyBuckets = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// Stats return a snapshot of the clients of the pool
+func (pool *ClientPool) Stats() PoolStats {
+	stats := PoolStats{Clients: make([]ClientStats, len(pool.clients))}
+	for i, client := range pool.clients {
+		stats.Clients[i] = client.Stats()
+		if stats.Clients[i].Available {
+			stats.Available++
+		}
+	}
+	return stats
+}
+
+// HealthHandler render the pool Stats as JSON, with status 503 when no client is available
+func (pool *ClientPool) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stats := pool.Stats()
+		w.Header().Set("Content-Type", "application/json")
+		if stats.Available == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(w).Encode(stats); err != nil {
+			log.FromContext(r.Context()).Errorf("encode client pool stats error: %v", err)
+		}
+	})
+}
+
+// Stats return a snapshot of the state and counters of the client.
+// Unlike IsAvailable, it never move an open circuit to half-open
+func (c *Client) Stats() ClientStats {
+	c.mu.Lock()
+	stats := ClientStats{
+		Label:       c.Label(),
+		Endpoint:    c.redacted,
+		State:       c.state.String(),
+		Available:   c.state == CircuitClosed && !c.lagging && !c.quarantined && c.budgetWait() == 0,
+		Lagging:     c.lagging,
+		Quarantined: c.quarantined,
+		ChainID:     c.chainID,
+		InFlight:    c.inFlight.Load(),
+	}
+	if c.state != CircuitClosed {
+		availableAt := c.availableAt
+		stats.AvailableAt = &availableAt
+	}
+	if c.lastErr != nil {
+		stats.LastError = c.lastErr.Error()
+	}
+	stats.Latency.EWMA = c.latency
+	c.mu.Unlock()
+
+	c.metrics.mu.Lock()
+	defer c.metrics.mu.Unlock()
+	stats.Requests = c.metrics.requests
+	stats.Benched = c.metrics.benched
+	stats.Errors = make(map[string]uint64, len(c.metrics.errors))
+	for class, count := range c.metrics.errors {
+		stats.Errors[class.String()] = count
+	}
+	stats.Latency.Count = c.metrics.count
+	stats.Latency.Sum = c.metrics.sum
+	stats.Latency.Buckets = make([]LatencyBucket, len(latencyBuckets))
+	var cumulative uint64
+	for i, upperBound := range latencyBuckets {
+		if i < len(c.metrics.buckets) {
+			cumulative += c.metrics.buckets[i]
+		}
+		stats.Latency.Buckets[i] = LatencyBucket{UpperBound: upperBound, Count: cumulative}
+	}
+	return stats
+}
+
+// observeRequest record a request sent through call, latency is ignored when it is zero
+func (m *clientMetrics) observeRequest(latency time.Duration, class ErrorClass, failed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests++
+	if failed {
+		if m.errors == nil {
+			m.errors = map[ErrorClass]uint64{}
+		}
+		m.errors[class]++
+	}
+	if latency <= 0 {
+		return
+	}
+	if m.buckets == nil {
+		m.buckets = make([]uint64, len(latencyBuckets)+1)
+	}
+	m.count++
+	m.sum += latency
+	bucket := len(latencyBuckets)
+	for i, upperBound := range latencyBuckets {
+		if latency <= upperBound {
+			bucket = i
+			break
+		}
+	}
+	m.buckets[bucket]++
+}
+
+func (m *clientMetrics) observeBench() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.benched++
+}
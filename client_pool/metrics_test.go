@@ -0,0 +1,79 @@
+package client_pool_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/duongtuttbn/toolkit/client_pool"
+	"github.com/duongtuttbn/toolkit/client_pool/rpctest"
+)
+
+func TestStatsAndHealthHandler(t *testing.T) {
+	chain := rpctest.NewChain(100)
+	down, up := newServer(t, chain), newServer(t, chain)
+	down.SetDown(true)
+	pool := newPool(t, client_pool.Config{Backoff: client_pool.ConstantBackoff(time.Minute)}, down, up)
+
+	ctx := testContext(t)
+	if _, err := pool.GetLatestBlockContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	stats := pool.Stats()
+	if stats.Available != 1 {
+		t.Fatalf("%d clients available, want 1", stats.Available)
+	}
+	downStats := stats.Clients[0]
+	if downStats.Available || downStats.Benched != 1 || downStats.Errors["network"] != 1 || downStats.AvailableAt == nil {
+		t.Fatalf("unexpected stats of the down client: %+v", downStats)
+	}
+	// the up client answered eth_chainId when the pool was built, then eth_blockNumber
+	if upStats := stats.Clients[1]; upStats.Requests != 2 || upStats.Latency.Count != 2 || len(upStats.Errors) != 0 {
+		t.Fatalf("unexpected stats of the up client: %+v", upStats)
+	}
+
+	recorder := httptest.NewRecorder()
+	pool.HealthHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("health status is %d, want 200", recorder.Code)
+	}
+	var rendered client_pool.PoolStats
+	if err := json.Unmarshal(recorder.Body.Bytes(), &rendered); err != nil {
+		t.Fatal(err)
+	}
+	if len(rendered.Clients) != 2 || rendered.Clients[0].State != client_pool.CircuitOpen.String() {
+		t.Fatalf("unexpected rendered stats: %s", recorder.Body.String())
+	}
+}
+
+func TestStatsRedactEndpointKeys(t *testing.T) {
+	const key = "abcdefghijklmnopqrstuvwxyz123456"
+	f := newFixture(t, 100, 1)
+	// nothing listen on port 1, the transport error embed the endpoint URL
+	pool, err := client_pool.NewBasicClientPool(client_pool.Config{
+		Backoff: client_pool.ConstantBackoff(time.Minute),
+		Endpoints: []client_pool.EndpointConfig{
+			{URL: "http://127.0.0.1:1/v3/" + key},
+			{URL: f.servers[0].URL + "/v3/" + key},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.GetLatestBlockContext(f.ctx); err != nil {
+		t.Fatal(err)
+	}
+	lastError := pool.Stats().Clients[0].LastError
+	if lastError == "" || strings.Contains(lastError, key) || !strings.Contains(lastError, "127.0.0.1:1/v3/xxxxx") {
+		t.Fatalf("last error is %q, want the transport error with the endpoint URL redacted", lastError)
+	}
+
+	recorder := httptest.NewRecorder()
+	pool.HealthHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if strings.Contains(recorder.Body.String(), key) {
+		t.Fatalf("health handler leaked the API key: %s", recorder.Body.String())
+	}
+}
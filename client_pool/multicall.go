@@ -0,0 +1,220 @@
+package client_pool
+
+import (
+	"context"
+	"strings"
+
+	"github.com/duongtuttbn/toolkit/log"
+	"github.com/duongtuttbn/toolkit/model"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+)
+
+type (
+	MulticallConfig struct {
+		// Address of the Multicall3 contract, default to its canonical deployment
+		// 0xcA11bde05977b3631167028862bE2a173976CA11
+		Address string `json:"address" yaml:"address"`
+		// BatchSize is the largest number of calls aggregated in one eth_call, default to 500
+		BatchSize int `json:"batch_size" yaml:"batch_size"`
+	}
+
+	// multicallCall is the Multicall3.Call3 struct
+	multicallCall struct {
+		Target       common.Address
+		AllowFailure bool
+		CallData     []byte
+	}
+
+	// multicallResult is the Multicall3.Result struct
+	multicallResult struct {
+		Success    bool   `json:"success"`
+		ReturnData []byte `json:"returnData"`
+	}
+)
+
+const (
+	defaultMulticallAddress   = "0xcA11bde05977b3631167028862bE2a173976CA11"
+	defaultMulticallBatchSize = 500
+	multicall3ABI             = "[{\"inputs\":[{\"components\":[{\"internalType\":\"address\",\"name\":\"target\",\"type\":\"address\"},{\"internalType\":\"bool\",\"name\":\"allowFailure\",\"type\":\"bool\"},{\"internalType\":\"bytes\",\"name\":\"callData\",\"type\":\"bytes\"}],\"internalType\":\"struct Multicall3.Call3[]\",\"name\":\"calls\",\"type\":\"tuple[]\"}],\"name\":\"aggregate3\",\"outputs\":[{\"components\":[{\"internalType\":\"bool\",\"name\":\"success\",\"type\":\"bool\"},{\"internalType\":\"bytes\",\"name\":\"returnData\",\"type\":\"bytes\"}],\"internalType\":\"struct Multicall3.Result[]\",\"name\":\"returnData\",\"type\":\"tuple[]\"}],\"stateMutability\":\"payable\",\"type\":\"function\"}]"
+)
+
+var (
+	multicallABI     = mustParseABI(multicall3ABI)
+	erc20ABI         = mustParseABI(tokenInfoABI)
+	liquidityPoolABI = mustParseABI(liquidityPoolInfoABI)
+
+	// ErrCallReverted is returned for a call of a multicall batch that reverted
+	ErrCallReverted = errors.New("call reverted")
+	// ErrInvalidAddress is returned for an address that is not 20 bytes in hexadecimal
+	ErrInvalidAddress = errors.New("invalid address")
+)
+
+// multicall run the calls through Multicall3 aggregate3, in as few eth_call as the batch size allows.
+// Calls that revert have a failed result instead of failing the batch
+func (pool *ClientPool) multicall(ctx context.Context, calls []multicallCall) ([]multicallResult, error) {
+	address := common.HexToAddress(defaultMulticallAddress)
+	if pool.config.Multicall.Address != "" {
+		var err error
+		if address, err = parseAddress(pool.config.Multicall.Address); err != nil {
+			return nil, errors.Wrap(err, "unable to use Multicall3 address")
+		}
+	}
+	batchSize := pool.config.Multicall.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultMulticallBatchSize
+	}
+	for i := range calls {
+		calls[i].AllowFailure = true
+	}
+
+	results := make([]multicallResult, 0, len(calls))
+	for start := 0; start < len(calls); start += batchSize {
+		chunk := calls[start:min(start+batchSize, len(calls))]
+		data, err := multicallABI.Pack("aggregate3", chunk)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to pack aggregate3 call")
+		}
+		output, err := pool.callContract(ctx, ethereum.CallMsg{To: &address, Data: data})
+		if err != nil {
+			return nil, err
+		}
+		unpacked, err := multicallABI.Unpack("aggregate3", output)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to unpack aggregate3 result")
+		}
+		chunkResults := *abi.ConvertType(unpacked[0], new([]multicallResult)).(*[]multicallResult)
+		if len(chunkResults) != len(chunk) {
+			return nil, errors.Errorf("aggregate3 returned %d results for %d calls", len(chunkResults), len(chunk))
+		}
+		results = append(results, chunkResults...)
+	}
+	return results, nil
+}
+
+// callContract run an eth_call on the latest block, retrying on another client when rate limited
+func (pool *ClientPool) callContract(ctx context.Context, msg ethereum.CallMsg) ([]byte, error) {
+	for {
+		client, err := pool.GetClientContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		output, err := call(withMethod(ctx, "eth_call"), client, func(ctx context.Context) ([]byte, error) {
+			return client.CallContract(ctx, msg, nil)
+		})
+		if err != nil {
+			if pool.retryable(err) && ctx.Err() == nil {
+				client.MarkError(err)
+				log.FromContext(ctx).Errorf("call contract %s error: %v", msg.To, err)
+				continue
+			} else {
+				return nil, err
+			}
+		}
+		client.MarkSuccess()
+		return output, nil
+	}
+}
+
+// GetLiquidityPoolInfos return the tokens of the given V2 style pools, fetched through Multicall3.
+// Pools whose tokens could not be fetched are reported in a BatchError
+func (pool *ClientPool) GetLiquidityPoolInfos(
+	ctx context.Context,
+	poolAddresses []string,
+) (_ map[string]*model.LiquidityPoolInfo, err error) {
+	ctx, end := pool.startSpan(ctx, "GetLiquidityPoolInfos", attrBatchSize.Int(len(poolAddresses)))
+	defer func() { end(err) }()
+	values, batchErr, err := pool.multicallViews(ctx, liquidityPoolABI, poolAddresses, []string{"token0", "token1"})
+	if err != nil {
+		return nil, err
+	}
+	pools := make(map[string]*model.LiquidityPoolInfo, len(poolAddresses))
+	for poolAddress, value := range values {
+		pools[poolAddress] = &model.LiquidityPoolInfo{
+			PoolAddress: poolAddress,
+			Token0:      value[0].(common.Address).String(),
+			Token1:      value[1].(common.Address).String(),
+		}
+	}
+	if len(batchErr) > 0 {
+		return pools, batchErr
+	}
+	return pools, nil
+}
+
+// multicallViews call the parameterless view methods on every address and unpack their single output.
+// An address is reported in the BatchError when it is invalid, or as soon as one of its calls reverted
+// or could not be unpacked
+func (pool *ClientPool) multicallViews(
+	ctx context.Context,
+	contractABI abi.ABI,
+	addresses []string,
+	methods []string,
+) (map[string][]interface{}, BatchError[string], error) {
+	batchErr := BatchError[string]{}
+	called := make([]string, 0, len(addresses))
+	calls := make([]multicallCall, 0, len(addresses)*len(methods))
+	for _, address := range addresses {
+		target, err := parseAddress(address)
+		if err != nil {
+			batchErr[address] = err
+			continue
+		}
+		called = append(called, address)
+		for _, method := range methods {
+			data, err := contractABI.Pack(method)
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "unable to pack %s call", method)
+			}
+			calls = append(calls, multicallCall{Target: target, CallData: data})
+		}
+	}
+	results, err := pool.multicall(ctx, calls)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	values := make(map[string][]interface{}, len(called))
+	for i, address := range called {
+		value := make([]interface{}, len(methods))
+		for j, method := range methods {
+			result := results[i*len(methods)+j]
+			if !result.Success {
+				err = errors.Wrapf(ErrCallReverted, "call %s", method)
+				break
+			}
+			var unpacked []interface{}
+			unpacked, err = contractABI.Unpack(method, result.ReturnData)
+			if err != nil {
+				err = errors.Wrapf(err, "unpack %s", method)
+				break
+			}
+			value[j] = unpacked[0]
+		}
+		if err != nil {
+			batchErr[address] = err
+			err = nil
+			continue
+		}
+		values[address] = value
+	}
+	return values, batchErr, nil
+}
+
+// parseAddress decode a hexadecimal address, which common.HexToAddress would silently truncate or pad
+func parseAddress(address string) (common.Address, error) {
+	if !common.IsHexAddress(address) {
+		return common.Address{}, errors.Wrapf(ErrInvalidAddress, "%q", address)
+	}
+	return common.HexToAddress(address), nil
+}
+
+func mustParseABI(definition string) abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(definition))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
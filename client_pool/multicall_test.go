@@ -0,0 +1,81 @@
+package client_pool_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/duongtuttbn/toolkit/client_pool"
+	"github.com/duongtuttbn/toolkit/client_pool/rpctest"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// setView script a parameterless view method of the contract to return the ABI words
+func setView(chain *rpctest.Chain, contract common.Address, signature string, words ...[]byte) {
+	var output []byte
+	for _, word := range words {
+		output = append(output, common.LeftPadBytes(word, 32)...)
+	}
+	chain.SetCall(contract, crypto.Keccak256([]byte(signature))[:4], output)
+}
+
+func TestGetLiquidityPoolInfosReportFailedPools(t *testing.T) {
+	f := newFixture(t, 100, 1)
+	token0, token1 := common.HexToAddress("0xa0"), common.HexToAddress("0xa1")
+	good, missing, garbage := common.HexToAddress("0xb0"), common.HexToAddress("0xb1"), common.HexToAddress("0xb2")
+	for _, pair := range []common.Address{good, missing, garbage} {
+		setView(f.chain, pair, "token0()", token0.Bytes())
+	}
+	setView(f.chain, good, "token1()", token1.Bytes())
+	// an answer shorter than an ABI word can not be unpacked as an address
+	f.chain.SetCall(garbage, crypto.Keccak256([]byte("token1()"))[:4], []byte{1})
+	pool := f.pool(t, client_pool.Config{Multicall: client_pool.MulticallConfig{BatchSize: 4}})
+
+	short, long := "0x1234", good.Hex()+"00"
+	addresses := []string{good.Hex(), missing.Hex(), garbage.Hex(), short, long, "not an address"}
+	pools, err := pool.GetLiquidityPoolInfos(f.ctx, addresses)
+	var batchErr client_pool.BatchError[string]
+	if !errors.As(err, &batchErr) || len(batchErr) != 5 {
+		t.Fatalf("got error %v, want the 5 failed pools", err)
+	}
+	if len(pools) != 1 || pools[good.Hex()] == nil {
+		t.Fatalf("got pools %v, want only %s", pools, good.Hex())
+	}
+	if info := pools[good.Hex()]; info.Token0 != token0.Hex() || info.Token1 != token1.Hex() {
+		t.Fatalf("got pool %+v, want tokens %s and %s", info, token0.Hex(), token1.Hex())
+	}
+	if !errors.Is(batchErr[missing.Hex()], client_pool.ErrCallReverted) {
+		t.Fatalf("got error %v for the pool without token1, want a reverted call", batchErr[missing.Hex()])
+	}
+	if err := batchErr[garbage.Hex()]; err == nil || errors.Is(err, client_pool.ErrCallReverted) {
+		t.Fatalf("got error %v for the pool answering garbage, want an unpack error", err)
+	}
+	for _, address := range []string{short, long, "not an address"} {
+		if !errors.Is(batchErr[address], client_pool.ErrInvalidAddress) {
+			t.Fatalf("got error %v for %q, want an invalid address", batchErr[address], address)
+		}
+	}
+	// the 6 calls of the valid addresses are aggregated by 4, the invalid addresses are not called
+	if requests := f.servers[0].Requests("eth_call"); requests != 2 {
+		t.Fatalf("server got %d eth_call, want 2 aggregate3 calls", requests)
+	}
+	// the single pool form return the error of the pool rather than the whole BatchError
+	if _, err := pool.GetLiquidityPoolInfoContext(f.ctx, missing.Hex()); !errors.Is(err, client_pool.ErrCallReverted) {
+		t.Fatalf("got error %v, want a reverted call", err)
+	}
+	if info, err := pool.GetLiquidityPoolInfoContext(f.ctx, good.Hex()); err != nil || info.Token1 != token1.Hex() {
+		t.Fatalf("got pool %+v (error %v), want %s", info, err, good.Hex())
+	}
+}
+
+func TestMulticallAddressIsValidated(t *testing.T) {
+	f := newFixture(t, 100, 1)
+	pool := f.pool(t, client_pool.Config{Multicall: client_pool.MulticallConfig{Address: "0xcA11"}})
+	_, err := pool.GetLiquidityPoolInfos(f.ctx, []string{common.HexToAddress("0xb0").Hex()})
+	if !errors.Is(err, client_pool.ErrInvalidAddress) {
+		t.Fatalf("got error %v, want the invalid Multicall3 address", err)
+	}
+	if requests := f.servers[0].Requests("eth_call"); requests != 0 {
+		t.Fatalf("server got %d eth_call, want none", requests)
+	}
+}
@@ -0,0 +1,123 @@
+package client_pool
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+type (
+	// nonceManager hand out the nonces of the accounts sending through a pool. The first nonce of an account
+	// is its pending nonce on the chain, the next ones are tracked locally so transactions can be sent
+	// without waiting for the previous ones to reach the endpoints
+	nonceManager struct {
+		mu       sync.Mutex
+		accounts map[common.Address]*accountNonces
+	}
+
+	// accountNonces is the nonce state of an account, its lock is held while the account is synced with the chain
+	// so the other accounts are not blocked by the sync
+	accountNonces struct {
+		mu     sync.Mutex
+		synced bool
+		next   uint64
+		// reserved are the nonces handed out whose transaction is neither done nor released
+		reserved map[uint64]struct{}
+		// released are the nonces below next given back, in increasing order, they are handed out first
+		released []uint64
+	}
+)
+
+func newNonceManager() *nonceManager {
+	return &nonceManager{accounts: make(map[common.Address]*accountNonces)}
+}
+
+func (m *nonceManager) account(account common.Address) *accountNonces {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	nonces, ok := m.accounts[account]
+	if !ok {
+		nonces = &accountNonces{reserved: make(map[uint64]struct{})}
+		m.accounts[account] = nonces
+	}
+	return nonces
+}
+
+// reserve return the next nonce of the account, it must be released if the transaction is not sent
+// and done otherwise
+func (m *nonceManager) reserve(ctx context.Context, pool *ClientPool, account common.Address) (uint64, error) {
+	nonces := m.account(account)
+	nonces.mu.Lock()
+	defer nonces.mu.Unlock()
+	if !nonces.synced {
+		pending, err := retry(ctx, pool, "eth_getTransactionCount", pool.retryable, func(ctx context.Context, client *Client) (uint64, error) {
+			return client.PendingNonceAt(ctx, account)
+		})
+		if err != nil {
+			return 0, err
+		}
+		// the released nonces below the pending one were used by transactions sent outside of the pool
+		nonces.released = slices.DeleteFunc(nonces.released, func(nonce uint64) bool { return nonce < pending })
+		nonces.next = pending
+		nonces.synced = true
+	}
+	for {
+		var nonce uint64
+		if len(nonces.released) > 0 {
+			nonce, nonces.released = nonces.released[0], nonces.released[1:]
+		} else {
+			nonce = nonces.next
+			nonces.next++
+		}
+		// a sync may move next back below nonces still reserved by transactions being sent
+		if _, reserved := nonces.reserved[nonce]; !reserved {
+			nonces.reserved[nonce] = struct{}{}
+			return nonce, nil
+		}
+	}
+}
+
+// release give back a nonce whose transaction was not sent. If later nonces were handed out meanwhile,
+// it is handed out again by the next reserve, so the gap is filled
+func (m *nonceManager) release(account common.Address, nonce uint64) {
+	nonces := m.account(account)
+	nonces.mu.Lock()
+	defer nonces.mu.Unlock()
+	if _, ok := nonces.reserved[nonce]; !ok {
+		return
+	}
+	delete(nonces.reserved, nonce)
+	if !nonces.synced || nonce >= nonces.next {
+		return
+	}
+	if nonce+1 < nonces.next {
+		index, _ := slices.BinarySearch(nonces.released, nonce)
+		nonces.released = slices.Insert(nonces.released, index, nonce)
+		return
+	}
+	// the last nonce is given back, along with the released ones right below it
+	nonces.next = nonce
+	for len(nonces.released) > 0 && nonces.released[len(nonces.released)-1] == nonces.next-1 {
+		nonces.next--
+		nonces.released = nonces.released[:len(nonces.released)-1]
+	}
+}
+
+// done end the reservation of a nonce whose transaction was sent, or that is already used on the chain
+func (m *nonceManager) done(account common.Address, nonce uint64) {
+	nonces := m.account(account)
+	nonces.mu.Lock()
+	defer nonces.mu.Unlock()
+	delete(nonces.reserved, nonce)
+}
+
+// reset forget the local nonce of the account, the next reserve sync it with the chain.
+// The nonces still reserved are not handed out again
+func (m *nonceManager) reset(account common.Address) {
+	nonces := m.account(account)
+	nonces.mu.Lock()
+	defer nonces.mu.Unlock()
+	nonces.synced = false
+}
@@ -0,0 +1,157 @@
+package client_pool
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/duongtuttbn/toolkit/client_pool/rpctest"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+func newNoncePool(t *testing.T) (*ClientPool, *rpctest.Server) {
+	t.Helper()
+	server := rpctest.NewServer(rpctest.NewChain(100))
+	t.Cleanup(server.Close)
+	pool, err := NewBasicClientPool(Config{RpcUrls: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pool, server
+}
+
+func reserveNonce(t *testing.T, ctx context.Context, pool *ClientPool, account common.Address, want uint64) {
+	t.Helper()
+	nonce, err := pool.nonces.reserve(ctx, pool, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nonce != want {
+		t.Fatalf("got nonce %d, want %d", nonce, want)
+	}
+}
+
+func TestNonceManagerFillsReleasedGaps(t *testing.T) {
+	pool, server := newNoncePool(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	account := common.HexToAddress("0xa1")
+
+	for nonce := uint64(0); nonce < 4; nonce++ {
+		reserveNonce(t, ctx, pool, account, nonce)
+	}
+	pool.nonces.done(account, 0)
+	pool.nonces.done(account, 3)
+	// nonce 1 is released while 2 and 3 are out, it is handed out again before 4
+	pool.nonces.release(account, 1)
+	reserveNonce(t, ctx, pool, account, 1)
+	reserveNonce(t, ctx, pool, account, 4)
+	// releasing the last nonces give them back along with the released ones below them
+	pool.nonces.release(account, 2)
+	pool.nonces.release(account, 4)
+	reserveNonce(t, ctx, pool, account, 2)
+	reserveNonce(t, ctx, pool, account, 4)
+	reserveNonce(t, ctx, pool, account, 5)
+	if requests := server.Requests("eth_getTransactionCount"); requests != 1 {
+		t.Fatalf("server got %d eth_getTransactionCount, want only the first nonce synced", requests)
+	}
+}
+
+func TestNonceManagerResetKeepsReservedNonces(t *testing.T) {
+	pool, server := newNoncePool(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	account := common.HexToAddress("0xa1")
+
+	reserveNonce(t, ctx, pool, account, 0)
+	reserveNonce(t, ctx, pool, account, 1)
+	reserveNonce(t, ctx, pool, account, 2)
+	pool.nonces.release(account, 1)
+	// nothing reached the chain yet, its pending nonce is still 0 but 0 and 2 are being sent
+	pool.nonces.reset(account)
+	reserveNonce(t, ctx, pool, account, 1)
+	reserveNonce(t, ctx, pool, account, 3)
+	if requests := server.Requests("eth_getTransactionCount"); requests != 2 {
+		t.Fatalf("server got %d eth_getTransactionCount, want the nonce synced again after the reset", requests)
+	}
+	// a done nonce is left to the chain, it is handed out again if the transaction never reached it
+	pool.nonces.done(account, 0)
+	pool.nonces.reset(account)
+	reserveNonce(t, ctx, pool, account, 0)
+}
+
+func TestNonceManagerLocksAccountsSeparately(t *testing.T) {
+	pool, server := newNoncePool(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	slow, fast := common.HexToAddress("0xa1"), common.HexToAddress("0xa2")
+	unblock := make(chan struct{})
+	release := sync.OnceFunc(func() { close(unblock) })
+	// the server only close once its handlers return
+	t.Cleanup(release)
+	server.Handle("eth_getTransactionCount", func(params []json.RawMessage) (interface{}, error) {
+		var account common.Address
+		if len(params) == 0 || json.Unmarshal(params[0], &account) != nil {
+			return nil, &rpctest.Error{Code: -32602, Message: "invalid transaction count request"}
+		}
+		if account == slow {
+			<-unblock
+			return hexutil.Uint64(7), nil
+		}
+		return hexutil.Uint64(3), nil
+	})
+
+	slowNonce := make(chan uint64)
+	go func() {
+		nonce, _ := pool.nonces.reserve(ctx, pool, slow)
+		slowNonce <- nonce
+	}()
+	// the sync of the slow account does not block the other accounts
+	for server.Requests("eth_getTransactionCount") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	reserveNonce(t, ctx, pool, fast, 3)
+	release()
+	if nonce := <-slowNonce; nonce != 7 {
+		t.Fatalf("got nonce %d of the slow account, want 7", nonce)
+	}
+}
+
+func TestNonceManagerConcurrentReserves(t *testing.T) {
+	pool, _ := newNoncePool(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	account := common.HexToAddress("0xa1")
+
+	var mu sync.Mutex
+	seen := make(map[uint64]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			nonce, err := pool.nonces.reserve(ctx, pool, account)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if seen[nonce] {
+				t.Errorf("nonce %d handed out twice", nonce)
+			}
+			seen[nonce] = true
+			// every other reservation is given back, its nonce may be handed out once more
+			if i%2 == 0 {
+				delete(seen, nonce)
+				pool.nonces.release(account, nonce)
+			} else {
+				pool.nonces.done(account, nonce)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
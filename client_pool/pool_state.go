@@ -0,0 +1,374 @@
+package client_pool
+
+import (
+	"context"
+	"math/big"
+	"strconv"
+
+	"github.com/duongtuttbn/toolkit/model"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pkg/errors"
+)
+
+type (
+	PoolStateConfig struct {
+		// V4StateView is the Uniswap V4 StateView lens of the chain, required to read V4 pools
+		V4StateView string `json:"v4_state_view" yaml:"v4_state_view"`
+		// V4PositionManager is the Uniswap V4 PositionManager of the chain, used to read the key
+		// (tokens, fee, tick spacing and hooks) of V4 pools
+		V4PositionManager string `json:"v4_position_manager" yaml:"v4_position_manager"`
+	}
+
+	// poolProbe is a call made to detect the type of a pool, along with where its result is stored
+	poolProbe struct {
+		pool   string
+		method string
+	}
+
+	// poolCalls collect the calls of a multicall round and the answers they got, keyed by pool and method
+	poolCalls struct {
+		calls   []multicallCall
+		probes  []poolProbe
+		answers map[string]map[string][]byte
+	}
+)
+
+// maxCurveCoins is the largest number of coins of a Curve pool
+const maxCurveCoins = 8
+
+var (
+	selectorToken0          = selector("token0()")
+	selectorToken1          = selector("token1()")
+	selectorFee             = selector("fee()")
+	selectorTickSpacing     = selector("tickSpacing()")
+	selectorSlot0           = selector("slot0()")
+	selectorLiquidity       = selector("liquidity()")
+	selectorGetReserves     = selector("getReserves()")
+	selectorGetPoolID       = selector("getPoolId()")
+	selectorGetVault        = selector("getVault()")
+	selectorGetPoolTokens   = selector("getPoolTokens(bytes32)")
+	selectorCoinsUint256    = selector("coins(uint256)")
+	selectorCoinsInt128     = selector("coins(int128)")
+	selectorBalancesUint256 = selector("balances(uint256)")
+	selectorBalancesInt128  = selector("balances(int128)")
+	selectorV4GetSlot0      = selector("getSlot0(bytes32)")
+	selectorV4GetLiquidity  = selector("getLiquidity(bytes32)")
+	selectorV4PoolKeys      = selector("poolKeys(bytes25)")
+
+	getPoolTokensOutputs = mustArguments("address[]", "uint256[]", "uint256")
+
+	errV4StateViewNotConfigured  = errors.New("Uniswap V4 StateView address is not configured")
+	errPoolStateAnswerIncomplete = errors.New("pool answered with incomplete data")
+
+	// poolAddressProbes are the calls made to a pool address to detect its type
+	poolAddressProbes = map[string][]byte{
+		"token0":       selectorToken0,
+		"token1":       selectorToken1,
+		"fee":          selectorFee,
+		"tickSpacing":  selectorTickSpacing,
+		"slot0":        selectorSlot0,
+		"liquidity":    selectorLiquidity,
+		"getReserves":  selectorGetReserves,
+		"getPoolId":    selectorGetPoolID,
+		"getVault":     selectorGetVault,
+		"coinsUint256": withUint(selectorCoinsUint256, 0),
+		"coinsInt128":  withUint(selectorCoinsInt128, 0),
+	}
+
+	// ErrUnknownPool is returned for a pool whose type could not be detected
+	ErrUnknownPool = errors.New("unknown pool type")
+)
+
+// GetPoolState detect the type of the pool and read its tokens, fees and liquidity.
+// poolAddress is the address of a Uniswap V2/V3, Curve or Balancer pool, or the 32 bytes id of a Uniswap V4 pool
+func (pool *ClientPool) GetPoolState(ctx context.Context, poolAddress string) (*model.PoolState, error) {
+	states, err := pool.GetPoolStates(ctx, []string{poolAddress})
+	var batchErr BatchError[string]
+	if errors.As(err, &batchErr) {
+		return nil, batchErr[poolAddress]
+	}
+	if err != nil {
+		return nil, err
+	}
+	return states[poolAddress], nil
+}
+
+// GetPoolStates is GetPoolState for many pools, read through Multicall3 in two rounds: one to detect
+// the pool types and read the single value fields, one for the token lists of Curve and Balancer pools.
+// Invalid addresses and pools that could not be read are reported in a BatchError
+func (pool *ClientPool) GetPoolStates(ctx context.Context, poolAddresses []string) (_ map[string]*model.PoolState, err error) {
+	ctx, end := pool.startSpan(ctx, "GetPoolStates", attrBatchSize.Int(len(poolAddresses)))
+	defer func() { end(err) }()
+	batchErr := BatchError[string]{}
+	detect := newPoolCalls()
+	for _, poolAddress := range poolAddresses {
+		if isPoolID(poolAddress) {
+			if err := pool.addV4Probes(detect, poolAddress); err != nil {
+				batchErr[poolAddress] = err
+			}
+			continue
+		}
+		target, err := parseAddress(poolAddress)
+		if err != nil {
+			batchErr[poolAddress] = err
+			continue
+		}
+		for method, data := range poolAddressProbes {
+			detect.add(poolAddress, method, target, data)
+		}
+	}
+	if err := detect.run(ctx, pool); err != nil {
+		return nil, err
+	}
+
+	states := make(map[string]*model.PoolState, len(poolAddresses))
+	tokenLists := newPoolCalls()
+	for _, poolAddress := range poolAddresses {
+		if _, failed := batchErr[poolAddress]; failed {
+			continue
+		}
+		state, err := detect.decode(poolAddress, tokenLists)
+		if err != nil {
+			batchErr[poolAddress] = err
+			continue
+		}
+		states[poolAddress] = state
+	}
+	if err := tokenLists.run(ctx, pool); err != nil {
+		return nil, err
+	}
+	for poolAddress, state := range states {
+		if err := tokenLists.decodeTokens(poolAddress, state); err != nil {
+			batchErr[poolAddress] = err
+			delete(states, poolAddress)
+		}
+	}
+	if len(batchErr) > 0 {
+		return states, batchErr
+	}
+	return states, nil
+}
+
+// addV4Probes add the calls reading a Uniswap V4 pool through the configured StateView and PositionManager,
+// it fails when they are not configured or not valid addresses
+func (pool *ClientPool) addV4Probes(calls *poolCalls, poolID string) error {
+	if pool.config.PoolState.V4StateView == "" {
+		return errV4StateViewNotConfigured
+	}
+	stateView, err := parseAddress(pool.config.PoolState.V4StateView)
+	if err != nil {
+		return errors.Wrap(err, "unable to use Uniswap V4 StateView address")
+	}
+	var positionManager common.Address
+	if pool.config.PoolState.V4PositionManager != "" {
+		if positionManager, err = parseAddress(pool.config.PoolState.V4PositionManager); err != nil {
+			return errors.Wrap(err, "unable to use Uniswap V4 PositionManager address")
+		}
+	}
+	id := common.HexToHash(poolID)
+	calls.add(poolID, "v4Slot0", stateView, append(selectorV4GetSlot0, id.Bytes()...))
+	calls.add(poolID, "v4Liquidity", stateView, append(selectorV4GetLiquidity, id.Bytes()...))
+	if positionManager != (common.Address{}) {
+		// poolKeys is keyed by the first 25 bytes of the pool id, left aligned in the word
+		key := make([]byte, common.HashLength)
+		copy(key, id[:25])
+		calls.add(poolID, "v4PoolKey", positionManager, append(selectorV4PoolKeys, key...))
+	}
+	return nil
+}
+
+func newPoolCalls() *poolCalls {
+	return &poolCalls{answers: map[string]map[string][]byte{}}
+}
+
+func (p *poolCalls) add(pool, method string, target common.Address, data []byte) {
+	p.calls = append(p.calls, multicallCall{Target: target, CallData: data})
+	p.probes = append(p.probes, poolProbe{pool: pool, method: method})
+}
+
+// run send the calls and keep the answers of those that succeeded
+func (p *poolCalls) run(ctx context.Context, pool *ClientPool) error {
+	if len(p.calls) == 0 {
+		return nil
+	}
+	results, err := pool.multicall(ctx, p.calls)
+	if err != nil {
+		return err
+	}
+	for i, result := range results {
+		if !result.Success || len(result.ReturnData) == 0 {
+			continue
+		}
+		probe := p.probes[i]
+		if p.answers[probe.pool] == nil {
+			p.answers[probe.pool] = map[string][]byte{}
+		}
+		p.answers[probe.pool][probe.method] = result.ReturnData
+	}
+	return nil
+}
+
+// answer return the words of the answer to method, or nil if the call failed or returned less than minWords
+func (p *poolCalls) answer(pool, method string, minWords int) []byte {
+	data := p.answers[pool][method]
+	if len(data) < minWords*common.HashLength {
+		return nil
+	}
+	return data
+}
+
+// decode build the state of a pool from the detection round, and queue the calls that read its tokens when
+// they are not known yet
+func (p *poolCalls) decode(poolAddress string, tokenLists *poolCalls) (*model.PoolState, error) {
+	state := &model.PoolState{PoolAddress: poolAddress}
+	if isPoolID(poolAddress) {
+		slot0 := p.answer(poolAddress, "v4Slot0", 4)
+		liquidity := p.answer(poolAddress, "v4Liquidity", 1)
+		if slot0 == nil || liquidity == nil {
+			return nil, ErrUnknownPool
+		}
+		state.Type = model.PoolTypeUniswapV4
+		state.PoolID = common.HexToHash(poolAddress).Hex()
+		state.PoolAddress = ""
+		state.SqrtPriceX96 = word(slot0, 0)
+		state.Tick = int32(signedWord(slot0, 1).Int64())
+		state.Fee = uint32(word(slot0, 3).Uint64())
+		state.Liquidity = word(liquidity, 0)
+		if key := p.answer(poolAddress, "v4PoolKey", 5); key != nil {
+			state.Tokens = []string{wordAddress(key, 0), wordAddress(key, 1)}
+			state.TickSpacing = int32(signedWord(key, 3).Int64())
+			state.Hooks = wordAddress(key, 4)
+		}
+		return state, nil
+	}
+
+	target := common.HexToAddress(poolAddress)
+	token0 := p.answer(poolAddress, "token0", 1)
+	token1 := p.answer(poolAddress, "token1", 1)
+	switch {
+	case p.answer(poolAddress, "getPoolId", 1) != nil && p.answer(poolAddress, "getVault", 1) != nil:
+		poolID := p.answer(poolAddress, "getPoolId", 1)[:common.HashLength]
+		vault := common.HexToAddress(wordAddress(p.answer(poolAddress, "getVault", 1), 0))
+		state.Type = model.PoolTypeBalancer
+		state.PoolID = hexutil.Encode(poolID)
+		tokenLists.add(poolAddress, "getPoolTokens", vault, append(selectorGetPoolTokens, poolID...))
+	case token0 != nil && token1 != nil && p.answer(poolAddress, "slot0", 2) != nil:
+		slot0 := p.answer(poolAddress, "slot0", 2)
+		state.Type = model.PoolTypeUniswapV3
+		state.Tokens = []string{wordAddress(token0, 0), wordAddress(token1, 0)}
+		state.SqrtPriceX96 = word(slot0, 0)
+		state.Tick = int32(signedWord(slot0, 1).Int64())
+		if fee := p.answer(poolAddress, "fee", 1); fee != nil {
+			state.Fee = uint32(word(fee, 0).Uint64())
+		}
+		if tickSpacing := p.answer(poolAddress, "tickSpacing", 1); tickSpacing != nil {
+			state.TickSpacing = int32(signedWord(tickSpacing, 0).Int64())
+		}
+		if liquidity := p.answer(poolAddress, "liquidity", 1); liquidity != nil {
+			state.Liquidity = word(liquidity, 0)
+		}
+	case token0 != nil && token1 != nil && p.answer(poolAddress, "getReserves", 2) != nil:
+		reserves := p.answer(poolAddress, "getReserves", 2)
+		state.Type = model.PoolTypeUniswapV2
+		state.Tokens = []string{wordAddress(token0, 0), wordAddress(token1, 0)}
+		state.Reserves = []*big.Int{word(reserves, 0), word(reserves, 1)}
+	case p.answer(poolAddress, "coinsUint256", 1) != nil || p.answer(poolAddress, "coinsInt128", 1) != nil:
+		coins, balances := selectorCoinsUint256, selectorBalancesUint256
+		if p.answer(poolAddress, "coinsUint256", 1) == nil {
+			coins, balances = selectorCoinsInt128, selectorBalancesInt128
+		}
+		state.Type = model.PoolTypeCurve
+		for i := 0; i < maxCurveCoins; i++ {
+			tokenLists.add(poolAddress, "coins"+strconv.Itoa(i), target, withUint(coins, uint64(i)))
+			tokenLists.add(poolAddress, "balances"+strconv.Itoa(i), target, withUint(balances, uint64(i)))
+		}
+	default:
+		return nil, ErrUnknownPool
+	}
+	return state, nil
+}
+
+// decodeTokens fill the tokens and reserves of Curve and Balancer pools from the token list round
+func (p *poolCalls) decodeTokens(poolAddress string, state *model.PoolState) error {
+	switch state.Type {
+	case model.PoolTypeBalancer:
+		data := p.answer(poolAddress, "getPoolTokens", 3)
+		if data == nil {
+			return errors.Wrap(errPoolStateAnswerIncomplete, "getPoolTokens")
+		}
+		unpacked, err := getPoolTokensOutputs.Unpack(data)
+		if err != nil {
+			return errors.Wrap(err, "unpack getPoolTokens")
+		}
+		for _, token := range unpacked[0].([]common.Address) {
+			state.Tokens = append(state.Tokens, token.String())
+		}
+		state.Reserves = unpacked[1].([]*big.Int)
+	case model.PoolTypeCurve:
+		// coins(i) revert past the last coin
+		for i := 0; i < maxCurveCoins; i++ {
+			coin := p.answer(poolAddress, "coins"+strconv.Itoa(i), 1)
+			if coin == nil {
+				break
+			}
+			state.Tokens = append(state.Tokens, wordAddress(coin, 0))
+			var reserve *big.Int
+			if balance := p.answer(poolAddress, "balances"+strconv.Itoa(i), 1); balance != nil {
+				reserve = word(balance, 0)
+			}
+			state.Reserves = append(state.Reserves, reserve)
+		}
+		if len(state.Tokens) == 0 {
+			return errors.Wrap(errPoolStateAnswerIncomplete, "coins")
+		}
+	}
+	return nil
+}
+
+// isPoolID report whether pool is a 32 bytes Uniswap V4 pool id rather than an address
+func isPoolID(pool string) bool {
+	data, err := hexutil.Decode(pool)
+	return err == nil && len(data) == common.HashLength
+}
+
+// selector return the 4 bytes selector of the function signature, capped so appending arguments always copy it
+func selector(signature string) []byte {
+	return crypto.Keccak256([]byte(signature))[:4:4]
+}
+
+// withUint append a uint256 argument to the selector
+func withUint(selector []byte, value uint64) []byte {
+	return append(selector, common.BigToHash(new(big.Int).SetUint64(value)).Bytes()...)
+}
+
+func word(data []byte, i int) *big.Int {
+	return new(big.Int).SetBytes(data[i*common.HashLength : (i+1)*common.HashLength])
+}
+
+// signedWord decode the i-th word of data as a two's complement signed integer
+func signedWord(data []byte, i int) *big.Int {
+	value := word(data, i)
+	if value.Bit(255) == 1 {
+		value.Sub(value, new(big.Int).Lsh(big.NewInt(1), 256))
+	}
+	return value
+}
+
+func wordAddress(data []byte, i int) string {
+	return common.BytesToAddress(data[i*common.HashLength : (i+1)*common.HashLength]).String()
+}
+
+func mustArguments(types ...string) abi.Arguments {
+	arguments := make(abi.Arguments, len(types))
+	for i, t := range types {
+		abiType, err := abi.NewType(t, "", nil)
+		if err != nil {
+			panic(err)
+		}
+		arguments[i] = abi.Argument{Type: abiType}
+	}
+	return arguments
+}
@@ -0,0 +1,130 @@
+package client_pool_test
+
+import (
+	"errors"
+	"math/big"
+	"slices"
+	"testing"
+
+	"github.com/duongtuttbn/toolkit/client_pool"
+	"github.com/duongtuttbn/toolkit/model"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// words encode the values as two's complement ABI words
+func words(values ...int64) []byte {
+	var data []byte
+	for _, value := range values {
+		data = append(data, math.U256Bytes(big.NewInt(value))...)
+	}
+	return data
+}
+
+func addressWord(address common.Address) int64 {
+	return new(big.Int).SetBytes(address.Bytes()).Int64()
+}
+
+func TestGetPoolStates(t *testing.T) {
+	f := newFixture(t, 100, 1)
+	tokenA, tokenB := common.HexToAddress("0xa0"), common.HexToAddress("0xa1")
+	v2, v3, unknown := common.HexToAddress("0xc2"), common.HexToAddress("0xc3"), common.HexToAddress("0xcf")
+	stateView, positionManager, hooks := common.HexToAddress("0xd0"), common.HexToAddress("0xd1"), common.HexToAddress("0xd2")
+	v4 := crypto.Keccak256Hash([]byte("pool key"))
+
+	for _, pair := range []common.Address{v2, v3} {
+		setView(f.chain, pair, "token0()", tokenA.Bytes())
+		setView(f.chain, pair, "token1()", tokenB.Bytes())
+	}
+	f.chain.SetCall(v2, crypto.Keccak256([]byte("getReserves()"))[:4], words(1000, 2000, 1_600_000_000))
+	// slot0 of a V3 pool: sqrtPriceX96, a negative tick, then the oracle and fee protocol fields
+	f.chain.SetCall(v3, crypto.Keccak256([]byte("slot0()"))[:4], words(79228162514, -887, 1, 1, 1, 0, 1))
+	f.chain.SetCall(v3, crypto.Keccak256([]byte("fee()"))[:4], words(3000))
+	f.chain.SetCall(v3, crypto.Keccak256([]byte("tickSpacing()"))[:4], words(60))
+	f.chain.SetCall(v3, crypto.Keccak256([]byte("liquidity()"))[:4], words(123456))
+
+	f.chain.SetCall(stateView, append(crypto.Keccak256([]byte("getSlot0(bytes32)"))[:4], v4.Bytes()...), words(4295128739, -10, 0, 500))
+	f.chain.SetCall(stateView, append(crypto.Keccak256([]byte("getLiquidity(bytes32)"))[:4], v4.Bytes()...), words(777))
+	// poolKeys is keyed by the first 25 bytes of the id
+	key := common.RightPadBytes(v4[:25], 32)
+	f.chain.SetCall(positionManager, append(crypto.Keccak256([]byte("poolKeys(bytes25)"))[:4], key...),
+		words(addressWord(tokenA), addressWord(tokenB), 500, 10, addressWord(hooks)))
+
+	pool := f.pool(t, client_pool.Config{PoolState: client_pool.PoolStateConfig{
+		V4StateView:       stateView.Hex(),
+		V4PositionManager: positionManager.Hex(),
+	}})
+	states, err := pool.GetPoolStates(f.ctx, []string{v2.Hex(), v3.Hex(), v4.Hex(), unknown.Hex(), "0xc4"})
+	var batchErr client_pool.BatchError[string]
+	if !errors.As(err, &batchErr) || len(batchErr) != 2 {
+		t.Fatalf("got error %v, want the unknown contract and the invalid address", err)
+	}
+	if !errors.Is(batchErr[unknown.Hex()], client_pool.ErrUnknownPool) || !errors.Is(batchErr["0xc4"], client_pool.ErrInvalidAddress) {
+		t.Fatalf("got errors %v", batchErr)
+	}
+	tokens := []string{tokenA.Hex(), tokenB.Hex()}
+
+	state := states[v2.Hex()]
+	if state == nil || state.Type != model.PoolTypeUniswapV2 || !slices.Equal(state.Tokens, tokens) ||
+		len(state.Reserves) != 2 || state.Reserves[0].Int64() != 1000 || state.Reserves[1].Int64() != 2000 {
+		t.Fatalf("unexpected V2 pool: %+v", state)
+	}
+	state = states[v3.Hex()]
+	if state == nil || state.Type != model.PoolTypeUniswapV3 || !slices.Equal(state.Tokens, tokens) ||
+		state.SqrtPriceX96.Int64() != 79228162514 || state.Tick != -887 || state.Fee != 3000 ||
+		state.TickSpacing != 60 || state.Liquidity.Int64() != 123456 || state.Reserves != nil {
+		t.Fatalf("unexpected V3 pool: %+v", state)
+	}
+	state = states[v4.Hex()]
+	if state == nil || state.Type != model.PoolTypeUniswapV4 || state.PoolID != v4.Hex() || state.PoolAddress != "" ||
+		!slices.Equal(state.Tokens, tokens) || state.SqrtPriceX96.Int64() != 4295128739 || state.Tick != -10 ||
+		state.Fee != 500 || state.TickSpacing != 10 || state.Liquidity.Int64() != 777 || state.Hooks != hooks.Hex() {
+		t.Fatalf("unexpected V4 pool: %+v", state)
+	}
+	// the pools are detected in a single aggregate3, there is no token list to read for them
+	if requests := f.servers[0].Requests("eth_call"); requests != 1 {
+		t.Fatalf("server got %d eth_call, want 1", requests)
+	}
+}
+
+func TestGetPoolState(t *testing.T) {
+	f := newFixture(t, 100, 1)
+	pair := common.HexToAddress("0xc2")
+	setView(f.chain, pair, "token0()", common.HexToAddress("0xa0").Bytes())
+	setView(f.chain, pair, "token1()", common.HexToAddress("0xa1").Bytes())
+	f.chain.SetCall(pair, crypto.Keccak256([]byte("getReserves()"))[:4], words(1, 2, 3))
+	pool := f.pool(t, client_pool.Config{})
+
+	state, err := pool.GetPoolState(f.ctx, pair.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Type != model.PoolTypeUniswapV2 || state.PoolAddress != pair.Hex() {
+		t.Fatalf("unexpected pool: %+v", state)
+	}
+	if _, err := pool.GetPoolState(f.ctx, common.HexToAddress("0xcf").Hex()); !errors.Is(err, client_pool.ErrUnknownPool) {
+		t.Fatalf("got error %v, want an unknown pool", err)
+	}
+	// V4 pools can not be read without the StateView lens
+	if _, err := pool.GetPoolState(f.ctx, crypto.Keccak256Hash([]byte("pool key")).Hex()); err == nil {
+		t.Fatal("read a V4 pool without StateView configured")
+	}
+}
+
+func TestGetPoolStateValidatesV4Addresses(t *testing.T) {
+	f := newFixture(t, 100, 1)
+	poolID := crypto.Keccak256Hash([]byte("pool key")).Hex()
+	for _, cfg := range []client_pool.PoolStateConfig{
+		{V4StateView: "0xd0"},
+		{V4StateView: common.HexToAddress("0xd0").Hex(), V4PositionManager: "0xd1"},
+	} {
+		pool := f.pool(t, client_pool.Config{PoolState: cfg})
+		if _, err := pool.GetPoolState(f.ctx, poolID); !errors.Is(err, client_pool.ErrInvalidAddress) {
+			t.Fatalf("got error %v with %+v, want ErrInvalidAddress", err, cfg)
+		}
+	}
+	if requests := f.servers[0].Requests("eth_call"); requests != 0 {
+		t.Fatalf("server got %d eth_call, want the pool not read with a misconfigured address", requests)
+	}
+}
@@ -0,0 +1,71 @@
+package promcollector
+
+import (
+	"github.com/duongtuttbn/toolkit/client_pool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector export the Stats of a ClientPool as Prometheus metrics, labelled by endpoint.
+// The endpoint label is the client Label, which is unique in a pool
+type Collector struct {
+	pool      *client_pool.ClientPool
+	requests  *prometheus.Desc
+	errors    *prometheus.Desc
+	benched   *prometheus.Desc
+	available *prometheus.Desc
+	inFlight  *prometheus.Desc
+	latency   *prometheus.Desc
+}
+
+// NewCollector return a collector of the pool metrics, whose names start with namespace
+func NewCollector(pool *client_pool.ClientPool, namespace string) *Collector {
+	name := func(metric string) string {
+		return prometheus.BuildFQName(namespace, "client_pool", metric)
+	}
+	return &Collector{
+		pool:      pool,
+		requests:  prometheus.NewDesc(name("requests_total"), "Requests sent to the endpoint.", []string{"endpoint"}, nil),
+		errors:    prometheus.NewDesc(name("errors_total"), "Failed requests by error class.", []string{"endpoint", "class"}, nil),
+		benched:   prometheus.NewDesc(name("benched_total"), "Times the endpoint circuit was opened.", []string{"endpoint"}, nil),
+		available: prometheus.NewDesc(name("available"), "Whether the endpoint currently serve requests.", []string{"endpoint"}, nil),
+		inFlight:  prometheus.NewDesc(name("in_flight"), "Requests currently sent to the endpoint.", []string{"endpoint"}, nil),
+		latency:   prometheus.NewDesc(name("request_duration_seconds"), "Duration of the requests.", []string{"endpoint"}, nil),
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.requests
+	ch <- c.errors
+	ch <- c.benched
+	ch <- c.available
+	ch <- c.inFlight
+	ch <- c.latency
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, stats := range c.pool.Stats().Clients {
+		ch <- prometheus.MustNewConstMetric(c.requests, prometheus.CounterValue, float64(stats.Requests), stats.Label)
+		for class, count := range stats.Errors {
+			ch <- prometheus.MustNewConstMetric(c.errors, prometheus.CounterValue, float64(count), stats.Label, class)
+		}
+		ch <- prometheus.MustNewConstMetric(c.benched, prometheus.CounterValue, float64(stats.Benched), stats.Label)
+		available := 0.0
+		if stats.Available {
+			available = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.available, prometheus.GaugeValue, available, stats.Label)
+		ch <- prometheus.MustNewConstMetric(c.inFlight, prometheus.GaugeValue, float64(stats.InFlight), stats.Label)
+
+		buckets := make(map[float64]uint64, len(stats.Latency.Buckets))
+		for _, bucket := range stats.Latency.Buckets {
+			buckets[bucket.UpperBound.Seconds()] = bucket.Count
+		}
+		ch <- prometheus.MustNewConstHistogram(
+			c.latency,
+			stats.Latency.Count,
+			stats.Latency.Sum.Seconds(),
+			buckets,
+			stats.Label,
+		)
+	}
+}
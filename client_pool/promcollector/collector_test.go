@@ -0,0 +1,58 @@
+package promcollector_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/duongtuttbn/toolkit/client_pool"
+	"github.com/duongtuttbn/toolkit/client_pool/promcollector"
+	"github.com/duongtuttbn/toolkit/client_pool/rpctest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCollectorGather(t *testing.T) {
+	server := rpctest.NewServer(rpctest.NewChain(100))
+	t.Cleanup(server.Close)
+	// two API keys of the same provider redact to the same URL
+	pool, err := client_pool.NewBasicClientPool(client_pool.Config{Endpoints: []client_pool.EndpointConfig{
+		{URL: server.URL + "/v3/abcdefghijklmnopqrstuvwxyz123456"},
+		{URL: server.URL + "/v3/zyxwvutsrqponmlkjihgfedcba654321"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := pool.GetLatestBlockContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(promcollector.NewCollector(pool, "test"))
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoints := map[string]map[string]bool{}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "state" {
+					t.Fatalf("metric %s is labelled by the circuit state", family.GetName())
+				}
+				if label.GetName() == "endpoint" {
+					if endpoints[family.GetName()] == nil {
+						endpoints[family.GetName()] = map[string]bool{}
+					}
+					endpoints[family.GetName()][label.GetValue()] = true
+				}
+			}
+		}
+	}
+	for _, name := range []string{"test_client_pool_requests_total", "test_client_pool_available", "test_client_pool_request_duration_seconds"} {
+		if len(endpoints[name]) != 2 {
+			t.Fatalf("metric %s has endpoints %v, want one series per client", name, endpoints[name])
+		}
+	}
+}
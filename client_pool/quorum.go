@@ -0,0 +1,193 @@
+package client_pool
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/duongtuttbn/toolkit/log"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+)
+
+type (
+	// QuorumResult is the logs agreed by a quorum of endpoints
+	QuorumResult struct {
+		Logs []types.Log
+		// Agreed is the labels of the endpoints that returned Logs
+		Agreed []string
+		// Dissented is the labels of the endpoints that returned other logs, they are marked as failed
+		Dissented []string
+		// Failed is the labels of the endpoints whose request failed
+		Failed []string
+	}
+
+	// ConsistencyError is returned when not enough endpoints agree on the logs of a block range
+	ConsistencyError struct {
+		FromBlock uint64
+		ToBlock   uint64
+		Quorum    int
+		// Agreed is the size of the largest group of endpoints returning the same logs
+		Agreed int
+		// Results is the number of logs returned by each endpoint
+		Results map[string]int
+		// Failed is the labels of the endpoints whose request failed
+		Failed []string
+	}
+
+	logsVote struct {
+		client *Client
+		logs   []types.Log
+		err    error
+	}
+)
+
+func (e *ConsistencyError) Error() string {
+	return fmt.Sprintf(
+		"Consistency error: block range [%d, %d]: %d endpoints agree but quorum is %d, results: %v, failed: %v",
+		e.FromBlock,
+		e.ToBlock,
+		e.Agreed,
+		e.Quorum,
+		e.Results,
+		e.Failed,
+	)
+}
+
+// GetLogsQuorum ask numClients distinct endpoints for the logs of [fromBlock, toBlock] and return the logs
+// returned by at least quorum of them. Each endpoint fetch the range in windows sized for it. An endpoint whose request fails is replaced by another available one.
+// Once the quorum is reached, the endpoints that returned other logs are marked as failed.
+// If the quorum is not reached, a *ConsistencyError is returned
+func (pool *ClientPool) GetLogsQuorum(
+	ctx context.Context,
+	filterQuery ethereum.FilterQuery,
+	fromBlock, toBlock uint64,
+	numClients, quorum int,
+) (_ *QuorumResult, err error) {
+	ctx, end := pool.startSpan(ctx, "GetLogsQuorum", blockAttrs(fromBlock, toBlock)...)
+	defer func() { end(err) }()
+	if quorum < 1 || quorum > numClients {
+		return nil, errors.Errorf("quorum must be between 1 and %d", numClients)
+	}
+	clients, err := pool.GetClientsContext(ctx, numClients)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to get clients")
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		used   = append([]*Client{}, clients...)
+		votes  = make([]logsVote, numClients)
+		failed []string
+	)
+	for i, client := range clients {
+		wg.Add(1)
+		go func(index int, client *Client) {
+			defer wg.Done()
+			for client != nil {
+				logs, err := pool.getClientLogs(ctx, client, filterQuery, fromBlock, toBlock)
+				if err == nil {
+					client.MarkSuccess()
+					votes[index] = logsVote{client: client, logs: logs}
+					return
+				}
+				votes[index] = logsVote{client: client, err: err}
+				if ctx.Err() != nil {
+					return
+				}
+				// a block whose logs are too large for the endpoint is not a failure of the endpoint,
+				// another endpoint with a higher limit may still vote
+				if pool.Classify(err) != ClassRangeTooLarge {
+					client.MarkError(err)
+				}
+				log.FromContext(ctx).Errorf("Fetch logs [%d to %d] on endpoint %v error: %v", fromBlock, toBlock, client.Label(), err)
+				mu.Lock()
+				failed = append(failed, client.Label())
+				client = nil
+				if replacements, _, _ := pool.nextClients(1, used...); replacements != nil {
+					client = replacements[0]
+					used = append(used, client)
+				}
+				mu.Unlock()
+			}
+		}(i, client)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// group the votes by identical logs and keep the largest group
+	var groups [][]int
+	for i, vote := range votes {
+		if vote.err != nil {
+			continue
+		}
+		grouped := false
+		for g, group := range groups {
+			if pool.compareListsLogs(votes[group[0]].logs, vote.logs) {
+				groups[g] = append(group, i)
+				grouped = true
+				break
+			}
+		}
+		if !grouped {
+			groups = append(groups, []int{i})
+		}
+	}
+	var best []int
+	for _, group := range groups {
+		if len(group) > len(best) {
+			best = group
+		}
+	}
+
+	if len(best) < quorum {
+		consistencyErr := &ConsistencyError{
+			FromBlock: fromBlock,
+			ToBlock:   toBlock,
+			Quorum:    quorum,
+			Agreed:    len(best),
+			Results:   make(map[string]int),
+			Failed:    failed,
+		}
+		for _, vote := range votes {
+			if vote.err == nil {
+				consistencyErr.Results[vote.client.Label()] = len(vote.logs)
+				log.FromContext(ctx).Infof(
+					"\t[Consistency error trace] Block range: [%d, %d]: Client %s returned %d logs",
+					fromBlock,
+					toBlock,
+					vote.client.Label(),
+					len(vote.logs),
+				)
+			}
+		}
+		return nil, consistencyErr
+	}
+
+	result := &QuorumResult{Logs: votes[best[0]].logs, Failed: failed}
+	for i, vote := range votes {
+		if vote.err != nil {
+			continue
+		}
+		if slices.Contains(best, i) {
+			result.Agreed = append(result.Agreed, vote.client.Label())
+			continue
+		}
+		result.Dissented = append(result.Dissented, vote.client.Label())
+		vote.client.MarkError(errors.Errorf("logs of block range [%d, %d] disagree with quorum", fromBlock, toBlock))
+		log.FromContext(ctx).Infof(
+			"Endpoint %s returned %d logs for block range [%d, %d] but quorum returned %d logs",
+			vote.client.Label(),
+			len(vote.logs),
+			fromBlock,
+			toBlock,
+			len(result.Logs),
+		)
+	}
+	return result, nil
+}
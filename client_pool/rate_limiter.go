@@ -0,0 +1,52 @@
+package client_pool
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// limiter build the token bucket described by the config, nil when the endpoint is unlimited
+func (cfg RateLimitConfig) limiter() *rate.Limiter {
+	if cfg.RequestsPerSecond <= 0 {
+		return nil
+	}
+	burst := cfg.Burst
+	if burst < 1 {
+		burst = 1
+	}
+	return rate.NewLimiter(rate.Limit(cfg.RequestsPerSecond), burst)
+}
+
+// budgetWait return how long until the client has budget for one more request
+func (c *Client) budgetWait() time.Duration {
+	if c.limiter == nil {
+		return 0
+	}
+	tokens := c.limiter.Tokens()
+	if tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - tokens) / float64(c.limiter.Limit()) * float64(time.Second))
+}
+
+// waitBudget take a token per request from the client bucket, waiting for them until ctx is done.
+// Requests above the burst, like the calls of a large batch, take their tokens one burst after another
+func (c *Client) waitBudget(ctx context.Context, requests int) error {
+	if c.limiter == nil {
+		return nil
+	}
+	for requests > 0 {
+		tokens := min(requests, c.limiter.Burst())
+		reservation := c.limiter.ReserveN(time.Now(), tokens)
+		if delay := reservation.Delay(); delay > 0 {
+			if err := SleepContext(ctx, delay); err != nil {
+				reservation.Cancel()
+				return err
+			}
+		}
+		requests -= tokens
+	}
+	return nil
+}
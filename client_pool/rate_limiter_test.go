@@ -0,0 +1,62 @@
+package client_pool_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/duongtuttbn/toolkit/client_pool"
+)
+
+func TestRateLimitedEndpointIsSkipped(t *testing.T) {
+	f := newFixture(t, 100, 2)
+	limited, spare := f.servers[0], f.servers[1]
+	pool := newPool(t, client_pool.Config{Endpoints: []client_pool.EndpointConfig{{
+		URL:       limited.URL,
+		Label:     limited.URL,
+		RateLimit: client_pool.RateLimitConfig{RequestsPerSecond: 1, Burst: 2},
+	}}}, spare)
+
+	// the eth_chainId of the construction and the first eth_blockNumber use up the burst of the limited endpoint,
+	// the next requests go to the spare one instead of waiting for a token
+	for i := 0; i < 4; i++ {
+		if _, err := pool.GetLatestBlockContext(f.ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if limited.Requests("eth_blockNumber") != 1 || spare.Requests("eth_blockNumber") != 3 {
+		t.Fatalf("limited endpoint got %d requests and spare %d, want 1 and 3",
+			limited.Requests("eth_blockNumber"), spare.Requests("eth_blockNumber"))
+	}
+}
+
+func TestRateLimitedEndpointIsWaitedFor(t *testing.T) {
+	f := newFixture(t, 100, 1)
+	server := f.servers[0]
+	pool := newPool(t, client_pool.Config{Endpoints: []client_pool.EndpointConfig{{
+		URL:       server.URL,
+		Label:     server.URL,
+		RateLimit: client_pool.RateLimitConfig{RequestsPerSecond: 10},
+	}}})
+
+	// the only endpoint is waited for, one request every 100ms once eth_chainId used the burst
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := pool.GetLatestBlockContext(f.ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Fatalf("3 requests took %v, want them spaced by the rate limit", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(f.ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := pool.GetLatestBlockContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want the deadline while waiting for the rate limit", err)
+	}
+	if requests := server.Requests("eth_blockNumber"); requests != 3 {
+		t.Fatalf("server got %d requests, want 3", requests)
+	}
+}
@@ -0,0 +1,234 @@
+package client_pool
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/duongtuttbn/toolkit/log"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/pkg/errors"
+)
+
+type (
+	ReceiptConfig struct {
+		// PollInterval is the first delay between two receipt requests of WaitForReceipt, default to 1s
+		PollInterval time.Duration `json:"poll_interval" yaml:"poll_interval"`
+		// MaxPollInterval cap the delay, which grow while the transaction is pending, default to 15s
+		MaxPollInterval time.Duration `json:"max_poll_interval" yaml:"max_poll_interval"`
+	}
+
+	// ReceiptResult is a receipt with the given number of confirmations, on a block that is still canonical
+	ReceiptResult struct {
+		Receipt     *types.Receipt
+		Status      uint64
+		BlockNumber uint64
+		BlockHash   common.Hash
+		GasUsed     uint64
+		// EffectiveGasPrice is the price paid per gas, base fee included
+		EffectiveGasPrice *big.Int
+		// Confirmations is the number of blocks from the receipt block to the head, both included
+		Confirmations uint64
+		// RevertReason is the decoded reason of a failed transaction, empty if it succeeded or gave no reason
+		RevertReason string
+		// Reorgs is the number of times the receipt block was reorged out while waiting
+		Reorgs int
+	}
+)
+
+const (
+	defaultReceiptPollInterval    = time.Second
+	defaultReceiptMaxPollInterval = 15 * time.Second
+)
+
+// ErrReorgedOut is returned by WaitForReceipt when ctx is done after the block of the receipt was reorged out
+// and before the transaction was mined again
+var ErrReorgedOut = errors.New("transaction block was reorged out")
+
+// Succeeded let you know that the transaction did not revert
+func (r *ReceiptResult) Succeeded() bool {
+	return r.Status == types.ReceiptStatusSuccessful
+}
+
+// WaitForReceipt poll the pool, with a growing delay, until the transaction is mined and its block is
+// confirmations blocks deep, the receipt block included. Before returning, it checks that the receipt block is
+// still canonical: a receipt whose block was reorged out is dropped and the transaction is waited for again.
+// The revert reason of a failed transaction is read by replaying it on the state before its block
+func (pool *ClientPool) WaitForReceipt(
+	ctx context.Context,
+	txHash common.Hash,
+	confirmations uint64,
+) (result *ReceiptResult, err error) {
+	ctx, end := pool.startSpan(ctx, "WaitForReceipt", attrTxHash.String(txHash.Hex()))
+	defer func() { end(err) }()
+	confirmations = max(confirmations, 1)
+	backoff := pool.config.Receipt.backoff()
+	var mined *types.Receipt
+	reorgs := 0
+	for attempt := 1; ; attempt++ {
+		receipt, err := pool.GetTransactionReceiptContext(ctx, txHash)
+		switch {
+		case err != nil && !errors.Is(err, ethereum.NotFound):
+			return nil, err
+		case mined == nil:
+			mined = receipt
+		case err != nil || receipt.BlockHash != mined.BlockHash:
+			// the endpoint may only be lagging, the known receipt is dropped if its block is not canonical anymore
+			canonical, err := pool.isCanonical(ctx, mined)
+			if err != nil {
+				return nil, err
+			}
+			if !canonical {
+				reorgs++
+				log.FromContext(ctx).Infof("block %d of transaction %s was reorged out", mined.BlockNumber, txHash)
+				mined = receipt
+			}
+		}
+
+		if mined != nil {
+			head, err := pool.GetLatestBlockContext(ctx)
+			if err != nil {
+				return nil, err
+			}
+			blockNumber := mined.BlockNumber.Uint64()
+			if head >= blockNumber && head-blockNumber+1 >= confirmations {
+				canonical, err := pool.isCanonical(ctx, mined)
+				if err != nil {
+					return nil, err
+				}
+				if canonical {
+					return pool.receiptResult(ctx, mined, head-blockNumber+1, reorgs)
+				}
+				reorgs++
+				log.FromContext(ctx).Infof("block %d of transaction %s was reorged out", blockNumber, txHash)
+				mined = nil
+			}
+		}
+
+		timer := time.NewTimer(backoff.Backoff(attempt, nil))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if mined == nil && reorgs > 0 {
+				return nil, errors.Wrap(ErrReorgedOut, ctx.Err().Error())
+			}
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// isCanonical let you know that the block of the receipt is still in the chain
+func (pool *ClientPool) isCanonical(ctx context.Context, receipt *types.Receipt) (bool, error) {
+	ctx = withBlockRange(ctx, "eth_getBlockByNumber", receipt.BlockNumber.Uint64(), receipt.BlockNumber.Uint64())
+	header, err := retry(ctx, pool, "eth_getBlockByNumber", pool.retryable, func(ctx context.Context, client *Client) (*types.Header, error) {
+		return client.HeaderByNumber(ctx, receipt.BlockNumber)
+	})
+	if errors.Is(err, ethereum.NotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return header.Hash() == receipt.BlockHash, nil
+}
+
+func (pool *ClientPool) receiptResult(ctx context.Context, receipt *types.Receipt, confirmations uint64, reorgs int) (*ReceiptResult, error) {
+	result := &ReceiptResult{
+		Receipt:           receipt,
+		Status:            receipt.Status,
+		BlockNumber:       receipt.BlockNumber.Uint64(),
+		BlockHash:         receipt.BlockHash,
+		GasUsed:           receipt.GasUsed,
+		EffectiveGasPrice: receipt.EffectiveGasPrice,
+		Confirmations:     confirmations,
+		Reorgs:            reorgs,
+	}
+	if result.Succeeded() {
+		return result, nil
+	}
+	reason, err := pool.revertReason(ctx, receipt)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		log.FromContext(ctx).Warnf("get revert reason of transaction %s error: %v", receipt.TxHash, err)
+	}
+	result.RevertReason = reason
+	return result, nil
+}
+
+// revertReason replay the failed transaction with eth_call on the state before its block and decode
+// the reason it reverted with
+func (pool *ClientPool) revertReason(ctx context.Context, receipt *types.Receipt) (string, error) {
+	type senderTx struct {
+		tx   *types.Transaction
+		from common.Address
+	}
+	found, err := retry(ctx, pool, "eth_getTransactionByHash", pool.retryable, func(ctx context.Context, client *Client) (senderTx, error) {
+		tx, _, err := client.TransactionByHash(ctx, receipt.TxHash)
+		if err != nil {
+			return senderTx{}, err
+		}
+		from, err := client.TransactionSender(ctx, tx, receipt.BlockHash, receipt.TransactionIndex)
+		return senderTx{tx: tx, from: from}, err
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "unable to get transaction")
+	}
+	msg := ethereum.CallMsg{
+		From:      found.from,
+		To:        found.tx.To(),
+		Gas:       found.tx.Gas(),
+		GasFeeCap: found.tx.GasFeeCap(),
+		GasTipCap: found.tx.GasTipCap(),
+		Value:     found.tx.Value(),
+		Data:      found.tx.Data(),
+	}
+	if found.tx.Type() == types.LegacyTxType {
+		msg.GasPrice, msg.GasFeeCap, msg.GasTipCap = found.tx.GasPrice(), nil, nil
+	}
+	parent := new(big.Int).Sub(receipt.BlockNumber, common.Big1)
+	_, err = retry(ctx, pool, "eth_call", pool.retryable, func(ctx context.Context, client *Client) ([]byte, error) {
+		return client.CallContract(ctx, msg, parent)
+	})
+	if err == nil {
+		// the transaction does not revert on the state before its block, it failed because of the transactions before it
+		return "", nil
+	}
+	return decodeRevert(err)
+}
+
+// decodeRevert return the reason of a reverted call, decoded from the revert data of the error
+// or read from its message
+func decodeRevert(err error) (string, error) {
+	var dataErr rpc.DataError
+	if errors.As(err, &dataErr) {
+		if data, ok := dataErr.ErrorData().(string); ok {
+			if reason, unpackErr := abi.UnpackRevert(common.FromHex(data)); unpackErr == nil {
+				return reason, nil
+			}
+		}
+	}
+	if message := err.Error(); strings.HasPrefix(message, "execution reverted") {
+		return strings.TrimPrefix(strings.TrimPrefix(message, "execution reverted"), ": "), nil
+	}
+	return "", err
+}
+
+// backoff is the delay between two receipt requests, growing while the transaction is pending
+func (cfg ReceiptConfig) backoff() BackoffPolicy {
+	initial, maxInterval := cfg.PollInterval, cfg.MaxPollInterval
+	if initial <= 0 {
+		initial = defaultReceiptPollInterval
+	}
+	if maxInterval <= 0 {
+		maxInterval = max(defaultReceiptMaxPollInterval, initial)
+	}
+	return ExponentialBackoff{Initial: initial, Max: maxInterval, Multiplier: 1.5, Jitter: 0.1}
+}
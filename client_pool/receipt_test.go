@@ -0,0 +1,85 @@
+package client_pool_test
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/duongtuttbn/toolkit/client_pool"
+	"github.com/duongtuttbn/toolkit/client_pool/rpctest"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestWaitForReceiptAcrossReorg(t *testing.T) {
+	chain := rpctest.NewChain(100)
+	server := newServer(t, chain)
+	pool := newPool(t, client_pool.Config{
+		Receipt: client_pool.ReceiptConfig{PollInterval: 5 * time.Millisecond, MaxPollInterval: 20 * time.Millisecond},
+	}, server)
+	sender := newSender(t, pool, client_pool.SenderConfig{})
+	recipient := common.HexToAddress("0x2")
+
+	ctx := testContext(t)
+	tx, err := sender.Send(ctx, client_pool.TxRequest{To: &recipient, Value: big.NewInt(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// polled wait until WaitForReceipt requested the receipt again since the chain changed
+	polled := func() {
+		seen := server.Requests("eth_getTransactionReceipt")
+		for server.Requests("eth_getTransactionReceipt") <= seen+1 && ctx.Err() == nil {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	type waited struct {
+		result *client_pool.ReceiptResult
+		err    error
+	}
+	done := make(chan waited, 1)
+	go func() {
+		result, err := pool.WaitForReceipt(ctx, tx.Hash(), 3)
+		done <- waited{result, err}
+	}()
+
+	// the transaction is mined in block 101, which is reorged out before it is 3 blocks deep
+	chain.Mine(1)
+	polled()
+	chain.Reorg(101)
+	polled()
+	chain.Mine(3)
+	got := <-done
+	if got.err != nil {
+		t.Fatal(got.err)
+	}
+	if got.result.Reorgs != 1 || got.result.BlockNumber != 102 || got.result.BlockHash != chain.Header(102).Hash() {
+		t.Fatalf("got receipt in block %d %s after %d reorgs, want block 102 after 1 reorg",
+			got.result.BlockNumber, got.result.BlockHash, got.result.Reorgs)
+	}
+	if got.result.Confirmations < 3 || !got.result.Succeeded() || got.result.EffectiveGasPrice.Cmp(rpctest.DefaultBaseFee) <= 0 {
+		t.Fatalf("unexpected result: %+v", got.result)
+	}
+}
+
+func TestWaitForReceiptRevertReason(t *testing.T) {
+	chain := rpctest.NewChain(100)
+	pool := newPool(t, client_pool.Config{
+		Receipt: client_pool.ReceiptConfig{PollInterval: 5 * time.Millisecond},
+	}, newServer(t, chain))
+	sender := newSender(t, pool, client_pool.SenderConfig{})
+	contract := common.HexToAddress("0x3")
+	chain.SetRevert(contract, "transfer amount exceeds balance")
+
+	ctx := testContext(t)
+	tx, err := sender.Send(ctx, client_pool.TxRequest{To: &contract, Data: []byte{0xa9, 0x05, 0x9c, 0xbb}, Gas: 100_000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain.Mine(1)
+	result, err := pool.WaitForReceipt(ctx, tx.Hash(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Succeeded() || result.RevertReason != "transfer amount exceeds balance" {
+		t.Fatalf("got status %d and revert reason %q", result.Status, result.RevertReason)
+	}
+}
@@ -0,0 +1,70 @@
+package client_pool
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const redactedValue = "xxxxx"
+
+// apiKeySegment match path segments that look like API keys, e.g. infura /v3/<key> or alchemy /v2/<key>
+var apiKeySegment = regexp.MustCompile(`^[A-Za-z0-9_-]{20,}$`)
+
+// redactURL hide the credentials embedded in an endpoint URL: user password, query values
+// and path segments that look like API keys
+func redactURL(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return redactedValue
+	}
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redactedValue)
+		} else {
+			u.User = url.User(redactedValue)
+		}
+	}
+	segments := strings.Split(u.Path, "/")
+	for i, segment := range segments {
+		if apiKeySegment.MatchString(segment) {
+			segments[i] = redactedValue
+		}
+	}
+	u.Path = strings.Join(segments, "/")
+	u.RawPath = ""
+	query := u.Query()
+	for key := range query {
+		query.Set(key, redactedValue)
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// redactedError hide the endpoint URL embedded in the message of a transport error, like
+// Post "https://mainnet.infura.io/v3/<key>": dial tcp ..., it unwraps to the original error
+type redactedError struct {
+	err      error
+	endpoint string
+	redacted string
+}
+
+func (e *redactedError) Error() string {
+	return strings.ReplaceAll(e.err.Error(), e.endpoint, e.redacted)
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// redactError replace the endpoint URL in the message of err by its redacted form
+func redactError(err error, endpoint string) error {
+	if err == nil || endpoint == "" {
+		return err
+	}
+	redacted := redactURL(endpoint)
+	if redacted == endpoint || !strings.Contains(err.Error(), endpoint) {
+		return err
+	}
+	return &redactedError{err: err, endpoint: endpoint, redacted: redacted}
+}
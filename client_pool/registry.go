@@ -0,0 +1,94 @@
+package client_pool
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrUnknownChainID is returned when a pool whose chain is not known is added to a Registry
+	ErrUnknownChainID = errors.New("chain id of the client pool is not known")
+	// ErrChainRegistered is returned when a Registry already hold a pool for the chain
+	ErrChainRegistered = errors.New("a client pool is already registered for the chain")
+)
+
+// Registry hold one ClientPool per chain, so multi-chain services can look their pools up by chain id
+type Registry struct {
+	mu    sync.RWMutex
+	pools map[uint64]*ClientPool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{pools: make(map[uint64]*ClientPool)}
+}
+
+// NewRegistryFromConfigs build a pool for each config and register it under the chain its endpoints serve.
+// If a pool cannot be built or registered, the pools already built are closed
+func NewRegistryFromConfigs(cfgs ...Config) (*Registry, error) {
+	registry := NewRegistry()
+	for i, cfg := range cfgs {
+		pool, err := NewBasicClientPool(cfg)
+		if err != nil {
+			registry.Close()
+			return nil, errors.Wrapf(err, "unable to init client pool %d", i)
+		}
+		if err := registry.Add(pool); err != nil {
+			pool.Close()
+			registry.Close()
+			return nil, err
+		}
+	}
+	return registry, nil
+}
+
+// Add register the pool under its ChainID
+func (r *Registry) Add(pool *ClientPool) error {
+	chainID := pool.ChainID()
+	if chainID == 0 {
+		return ErrUnknownChainID
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.pools[chainID]; ok {
+		return errors.Wrapf(ErrChainRegistered, "chain %d", chainID)
+	}
+	r.pools[chainID] = pool
+	return nil
+}
+
+// Pool return the pool of the chain, nil if there is none
+func (r *Registry) Pool(chainID uint64) *ClientPool {
+	pool, _ := r.Lookup(chainID)
+	return pool
+}
+
+// Lookup return the pool of the chain and whether there is one
+func (r *Registry) Lookup(chainID uint64) (*ClientPool, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	pool, ok := r.pools[chainID]
+	return pool, ok
+}
+
+// Close close every registered pool
+func (r *Registry) Close() {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, pool := range r.pools {
+		pool.Close()
+	}
+}
+
+// ChainIDs return the registered chains in increasing order
+func (r *Registry) ChainIDs() []uint64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	chainIDs := make([]uint64, 0, len(r.pools))
+	for chainID := range r.pools {
+		chainIDs = append(chainIDs, chainID)
+	}
+	slices.Sort(chainIDs)
+	return chainIDs
+}
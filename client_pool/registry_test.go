@@ -0,0 +1,87 @@
+package client_pool_test
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/duongtuttbn/toolkit/client_pool"
+	"github.com/duongtuttbn/toolkit/client_pool/rpctest"
+)
+
+// trackConnections serve the server through a proxy, and return its URL and its number of open connections
+func trackConnections(t *testing.T, server *rpctest.Server) (string, *atomic.Int64) {
+	t.Helper()
+	target, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	open := &atomic.Int64{}
+	proxy := httptest.NewUnstartedServer(httputil.NewSingleHostReverseProxy(target))
+	proxy.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			open.Add(1)
+		case http.StateClosed, http.StateHijacked:
+			open.Add(-1)
+		}
+	}
+	proxy.Start()
+	t.Cleanup(proxy.Close)
+	return proxy.URL, open
+}
+
+func TestRegistry(t *testing.T) {
+	ethereumChain, bsc := rpctest.NewChain(100), rpctest.NewChain(200)
+	ethereumChain.SetChainID(1)
+	bsc.SetChainID(56)
+	ethereumServer, bscServer := newServer(t, ethereumChain), newServer(t, bsc)
+	registry, err := client_pool.NewRegistryFromConfigs(
+		client_pool.Config{RpcUrls: ethereumServer.URL},
+		client_pool.Config{RpcUrls: bscServer.URL},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := testContext(t)
+	block, err := registry.Pool(56).GetLatestBlockContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block != 200 {
+		t.Fatalf("got block %d from the chain 56 pool, want 200", block)
+	}
+	if registry.Pool(137) != nil {
+		t.Fatal("got a pool for an unregistered chain")
+	}
+	if err := registry.Add(newPool(t, client_pool.Config{}, bscServer)); !errors.Is(err, client_pool.ErrChainRegistered) {
+		t.Fatalf("got error %v, want ErrChainRegistered", err)
+	}
+}
+
+func TestRegistryClosesPoolsOnError(t *testing.T) {
+	f := newFixture(t, 100, 1)
+	endpoint, open := trackConnections(t, f.servers[0])
+
+	// the first pool is built and keeps its connection open, then the second config fails
+	_, err := client_pool.NewRegistryFromConfigs(
+		client_pool.Config{RpcUrls: endpoint},
+		client_pool.Config{Endpoints: []client_pool.EndpointConfig{{URL: f.servers[0].URL}, {URL: "://invalid"}}},
+	)
+	if err == nil {
+		t.Fatal("built a registry with an invalid endpoint")
+	}
+	for open.Load() > 0 && f.ctx.Err() == nil {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if connections := open.Load(); connections != 0 {
+		t.Fatalf("the first pool still has %d open connections, want it closed", connections)
+	}
+}
@@ -0,0 +1,235 @@
+package rpctest
+
+import (
+	"encoding/binary"
+	"math/big"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+type (
+	// Chain is the data served by mock servers. Servers sharing a Chain agree with each other
+	// unless faults are injected on one of them
+	Chain struct {
+		mu       sync.RWMutex
+		chainID  uint64
+		headers  []*types.Header
+		logs     []types.Log
+		receipts map[common.Hash]*types.Receipt
+		calls    map[callKey][]byte
+		baseFee  *big.Int
+		tip      *big.Int
+		// pending is the transaction pool, by sender and nonce
+		pending map[common.Address]map[uint64]*types.Transaction
+		// nonces is the number of mined transactions of each sender
+		nonces map[common.Address]uint64
+		mined  map[common.Hash]minedTx
+		// reverts hold the revert data of the contracts whose calls and transactions fail
+		reverts map[common.Address][]byte
+		// salt make the headers of a reorg differ from the ones they replace
+		salt uint64
+	}
+
+	callKey struct {
+		to   common.Address
+		data string
+	}
+)
+
+const (
+	// DefaultChainID is the chain id of a new Chain
+	DefaultChainID = 1337
+	// GenesisTime is the timestamp of block 0
+	GenesisTime = 1_600_000_000
+	// BlockTime is the number of seconds between two blocks
+	BlockTime = 12
+)
+
+// NewChain return a chain whose blocks 0 to head are mined, one every BlockTime seconds from GenesisTime
+func NewChain(head uint64) *Chain {
+	c := &Chain{
+		chainID:  DefaultChainID,
+		receipts: map[common.Hash]*types.Receipt{},
+		calls:    map[callKey][]byte{},
+		baseFee:  new(big.Int).Set(DefaultBaseFee),
+		tip:      new(big.Int).Set(DefaultTip),
+		pending:  map[common.Address]map[uint64]*types.Transaction{},
+		nonces:   map[common.Address]uint64{},
+		mined:    map[common.Hash]minedTx{},
+		reverts:  map[common.Address][]byte{},
+	}
+	c.mine(0, head, false)
+	return c
+}
+
+// SetChainID change the id returned by eth_chainId
+func (c *Chain) SetChainID(chainID uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.chainID = chainID
+}
+
+// ChainID return the id returned by eth_chainId
+func (c *Chain) ChainID() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.chainID
+}
+
+// Head return the number of the latest block
+func (c *Chain) Head() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return uint64(len(c.headers) - 1)
+}
+
+// Mine add n blocks on top of the head, the first one include the pending transactions that can be executed
+func (c *Chain) Mine(n uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	head := uint64(len(c.headers) - 1)
+	c.mine(head+1, head+n, true)
+}
+
+// Reorg replace the blocks from the given number up to the head with blocks of different hashes.
+// Logs and receipts of the replaced blocks are dropped, newLogs are added in their place.
+// The transactions of the replaced blocks go back to the pool, the next Mine include them again
+func (c *Chain) Reorg(from uint64, newLogs ...types.Log) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	head := uint64(len(c.headers) - 1)
+	if from > head {
+		return
+	}
+	c.salt++
+	c.headers = c.headers[:from]
+	c.unmine(from)
+	c.mine(from, head, false)
+	logs := c.logs[:0]
+	for _, log := range c.logs {
+		if log.BlockNumber < from {
+			logs = append(logs, log)
+		}
+	}
+	c.logs = logs
+	c.addLogs(newLogs)
+}
+
+// AddLogs add logs to the chain, their block hash is filled when they are served
+func (c *Chain) AddLogs(logs ...types.Log) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.addLogs(logs)
+}
+
+// AddReceipt make the receipt available through eth_getTransactionReceipt
+func (c *Chain) AddReceipt(receipt *types.Receipt) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.receipts[receipt.TxHash] = receipt
+}
+
+// SetCall script the return data of an eth_call to the contract with the given call data.
+// Calls that are not scripted revert. Calls through Multicall3 aggregate3 are answered from the same script
+func (c *Chain) SetCall(to common.Address, data []byte, result []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls[callKey{to: to, data: string(data)}] = result
+}
+
+// Header return the current header of the block, nil if it is not mined
+func (c *Chain) Header(number uint64) *types.Header {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if number >= uint64(len(c.headers)) {
+		return nil
+	}
+	return types.CopyHeader(c.headers[number])
+}
+
+// Logs return the logs of the blocks in [from, to], with the hash of their current block
+func (c *Chain) Logs(from, to uint64) []types.Log {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var logs []types.Log
+	for _, log := range c.logs {
+		if log.BlockNumber >= from && log.BlockNumber <= to && log.BlockNumber < uint64(len(c.headers)) {
+			log.BlockHash = c.headers[log.BlockNumber].Hash()
+			logs = append(logs, log)
+		}
+	}
+	return logs
+}
+
+func (c *Chain) receipt(txHash common.Hash) *types.Receipt {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.receipts[txHash]
+}
+
+// SetRevert make the calls and the transactions to the contract revert with Error(reason)
+func (c *Chain) SetRevert(to common.Address, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, err := revertError.Inputs.Pack(reason)
+	if err != nil {
+		panic(err)
+	}
+	c.reverts[to] = append(append([]byte{}, revertError.ID...), data...)
+}
+
+// revertData return the revert data of the calls to the contract, false if they do not revert
+func (c *Chain) revertData(to common.Address) ([]byte, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	data, ok := c.reverts[to]
+	return data, ok
+}
+
+func (c *Chain) call(to common.Address, data []byte) ([]byte, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	result, ok := c.calls[callKey{to: to, data: string(data)}]
+	return result, ok
+}
+
+// mine append the blocks from to to, the first one include the pending transactions when includePending is set.
+// c.mu must be held
+func (c *Chain) mine(from, to uint64, includePending bool) {
+	for number := from; number <= to; number++ {
+		extra := make([]byte, 8)
+		binary.BigEndian.PutUint64(extra, c.salt)
+		header := &types.Header{
+			Number:      new(big.Int).SetUint64(number),
+			Time:        GenesisTime + number*BlockTime,
+			Difficulty:  big.NewInt(0),
+			GasLimit:    30_000_000,
+			UncleHash:   types.EmptyUncleHash,
+			TxHash:      types.EmptyTxsHash,
+			ReceiptHash: types.EmptyReceiptsHash,
+			Extra:       extra,
+			BaseFee:     new(big.Int).Set(c.baseFee),
+		}
+		if number > 0 {
+			header.ParentHash = c.headers[number-1].Hash()
+		}
+		if includePending && number == from {
+			c.include(header)
+		}
+		c.headers = append(c.headers, header)
+	}
+}
+
+// addLogs append the logs and keep them ordered by block and index, c.mu must be held
+func (c *Chain) addLogs(logs []types.Log) {
+	c.logs = append(c.logs, logs...)
+	sort.SliceStable(c.logs, func(i, j int) bool {
+		if c.logs[i].BlockNumber != c.logs[j].BlockNumber {
+			return c.logs[i].BlockNumber < c.logs[j].BlockNumber
+		}
+		return c.logs[i].Index < c.logs[j].Index
+	})
+}
@@ -0,0 +1,649 @@
+package rpctest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+type (
+	// Server is an in-process JSON-RPC endpoint serving a Chain, with scripted faults
+	Server struct {
+		// URL of the endpoint, to be used in client_pool.Config
+		URL string
+
+		chain      *Chain
+		httpServer *httptest.Server
+
+		mu          sync.Mutex
+		latency     time.Duration
+		down        bool
+		rateLimited int
+		retryAfter  time.Duration
+		headLag     uint64
+		maxLogRange uint64
+		maxBatch    int
+		tamperLogs  func([]types.Log) []types.Log
+		faults      map[string]*fault
+		handlers    map[string]HandlerFunc
+		requests    map[string]int
+	}
+
+	// HandlerFunc answer a JSON-RPC method in place of the built-in handler
+	HandlerFunc func(params []json.RawMessage) (interface{}, error)
+
+	// Error is a JSON-RPC error answered by the server
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		// Data is the revert data of a reverted call
+		Data hexutil.Bytes `json:"data,omitempty"`
+	}
+
+	fault struct {
+		remaining int
+		err       *Error
+	}
+
+	request struct {
+		ID     json.RawMessage   `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+
+	response struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Result  interface{}     `json:"result,omitempty"`
+		Error   *Error          `json:"error,omitempty"`
+	}
+
+	callMsg struct {
+		To    common.Address `json:"to"`
+		Data  hexutil.Bytes  `json:"data"`
+		Input hexutil.Bytes  `json:"input"`
+	}
+
+	filterQuery struct {
+		FromBlock string            `json:"fromBlock"`
+		ToBlock   string            `json:"toBlock"`
+		Address   json.RawMessage   `json:"address"`
+		Topics    []json.RawMessage `json:"topics"`
+	}
+
+	multicallCall struct {
+		Target       common.Address
+		AllowFailure bool
+		CallData     []byte
+	}
+
+	multicallResult struct {
+		Success    bool   `json:"success"`
+		ReturnData []byte `json:"returnData"`
+	}
+)
+
+const (
+	// ErrCodeRangeTooLarge is the code of the error answered when eth_getLogs exceed the max log range
+	ErrCodeRangeTooLarge = -32005
+	// ErrCodeReverted is the code of the error answered by eth_call when the call is not scripted
+	ErrCodeReverted = 3
+)
+
+// MulticallAddress is the address where Multicall3 aggregate3 is emulated
+var MulticallAddress = common.HexToAddress("0xcA11bde05977b3631167028862bE2a173976CA11")
+
+// revertError is the Error(string) of the solidity require and revert statements
+var revertError = abi.NewMethod("Error", "Error", abi.Function, "", false, false, abi.Arguments{{Type: mustType("string")}}, nil)
+
+var aggregate3 = func() abi.Method {
+	parsed, err := abi.JSON(strings.NewReader(`[{"inputs":[{"components":[{"name":"target","type":"address"},{"name":"allowFailure","type":"bool"},{"name":"callData","type":"bytes"}],"name":"calls","type":"tuple[]"}],"name":"aggregate3","outputs":[{"components":[{"name":"success","type":"bool"},{"name":"returnData","type":"bytes"}],"name":"returnData","type":"tuple[]"}],"stateMutability":"payable","type":"function"}]`))
+	if err != nil {
+		panic(err)
+	}
+	return parsed.Methods["aggregate3"]
+}()
+
+// NewServer start a server for the chain, it must be closed with Close
+func NewServer(chain *Chain) *Server {
+	s := &Server{
+		chain:    chain,
+		faults:   map[string]*fault{},
+		handlers: map[string]HandlerFunc{},
+		requests: map[string]int{},
+	}
+	s.httpServer = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.httpServer.URL
+	return s
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Close shut the server down
+func (s *Server) Close() {
+	s.httpServer.Close()
+}
+
+// SetLatency delay every answer by d
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// SetDown make the server answer every request with HTTP 503
+func (s *Server) SetDown(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down = down
+}
+
+// RateLimit answer the next n HTTP requests with HTTP 429, and a Retry-After header when retryAfter is set
+func (s *Server) RateLimit(n int, retryAfter time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rateLimited = n
+	s.retryAfter = retryAfter
+}
+
+// Fail answer the next n calls of the method with err, n < 0 fails them until Fail is called again with 0
+func (s *Server) Fail(method string, n int, err *Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n == 0 {
+		delete(s.faults, method)
+		return
+	}
+	s.faults[method] = &fault{remaining: n, err: err}
+}
+
+// SetHeadLag make the server serve the chain as if it was lag blocks behind its head
+func (s *Server) SetHeadLag(lag uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.headLag = lag
+}
+
+// SetMaxLogRange make eth_getLogs fail with a range too large error, suggesting a smaller range like infura,
+// when more than blocks blocks are requested. Zero remove the limit
+func (s *Server) SetMaxLogRange(blocks uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxLogRange = blocks
+}
+
+// SetMaxBatchSize make the server answer the batches of more than size calls with a single error object,
+// as geth does when a batch is too large. A negative size reject every batch, zero remove the limit
+func (s *Server) SetMaxBatchSize(size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxBatch = size
+}
+
+// TamperLogs pass the logs answered by eth_getLogs through fn, to make the server inconsistent with the others
+func (s *Server) TamperLogs(fn func([]types.Log) []types.Log) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tamperLogs = fn
+}
+
+// Handle answer the method with fn instead of the built-in handler
+func (s *Server) Handle(method string, fn HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[method] = fn
+}
+
+// Requests return the number of calls of the method the server received, batched calls included
+func (s *Server) Requests(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[method]
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	latency, down := s.latency, s.down
+	rateLimited := s.rateLimited > 0
+	if rateLimited {
+		s.rateLimited--
+	}
+	retryAfter, maxBatch := s.retryAfter, s.maxBatch
+	s.mu.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(latency):
+		}
+	}
+	if down {
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if rateLimited {
+		if retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int((retryAfter+time.Second-1)/time.Second)))
+		}
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var requests []request
+		if err := json.Unmarshal(body, &requests); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if maxBatch < 0 || maxBatch > 0 && len(requests) > maxBatch {
+			_ = json.NewEncoder(w).Encode(response{
+				JSONRPC: "2.0",
+				ID:      json.RawMessage("null"),
+				Error:   &Error{Code: -32600, Message: "batch too large"},
+			})
+			return
+		}
+		responses := make([]response, len(requests))
+		for i, req := range requests {
+			responses[i] = s.answer(req)
+		}
+		_ = json.NewEncoder(w).Encode(responses)
+		return
+	}
+	var req request
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(s.answer(req))
+}
+
+func (s *Server) answer(req request) response {
+	resp := response{JSONRPC: "2.0", ID: req.ID}
+	s.mu.Lock()
+	s.requests[req.Method]++
+	handler := s.handlers[req.Method]
+	if f := s.faults[req.Method]; f != nil {
+		if f.remaining > 0 {
+			f.remaining--
+			if f.remaining == 0 {
+				delete(s.faults, req.Method)
+			}
+		}
+		s.mu.Unlock()
+		resp.Error = f.err
+		return resp
+	}
+	s.mu.Unlock()
+
+	if handler == nil {
+		handler = s.builtin(req.Method)
+	}
+	if handler == nil {
+		resp.Error = &Error{Code: -32601, Message: fmt.Sprintf("the method %s does not exist/is not available", req.Method)}
+		return resp
+	}
+	result, err := handler(req.Params)
+	if err != nil {
+		rpcErr, ok := err.(*Error)
+		if !ok {
+			rpcErr = &Error{Code: -32000, Message: err.Error()}
+		}
+		resp.Error = rpcErr
+		return resp
+	}
+	resp.Result = result
+	if result == nil {
+		resp.Result = json.RawMessage("null")
+	}
+	return resp
+}
+
+func (s *Server) builtin(method string) HandlerFunc {
+	switch method {
+	case "eth_chainId":
+		return func([]json.RawMessage) (interface{}, error) {
+			return hexutil.Uint64(s.chain.ChainID()), nil
+		}
+	case "eth_blockNumber":
+		return func([]json.RawMessage) (interface{}, error) {
+			return hexutil.Uint64(s.head()), nil
+		}
+	case "eth_getBlockByNumber":
+		return s.getBlockByNumber
+	case "eth_getLogs":
+		return s.getLogs
+	case "eth_getTransactionReceipt":
+		return s.getTransactionReceipt
+	case "eth_getTransactionByHash":
+		return s.getTransactionByHash
+	case "eth_call":
+		return s.call
+	case "eth_getStorageAt":
+		return func([]json.RawMessage) (interface{}, error) {
+			return common.Hash{}, nil
+		}
+	case "eth_sendRawTransaction":
+		return s.sendRawTransaction
+	case "eth_getTransactionCount":
+		return s.getTransactionCount
+	case "eth_estimateGas":
+		return s.estimateGas
+	case "eth_maxPriorityFeePerGas":
+		return func([]json.RawMessage) (interface{}, error) {
+			return (*hexutil.Big)(s.chain.suggestedTip()), nil
+		}
+	case "eth_gasPrice":
+		return func([]json.RawMessage) (interface{}, error) {
+			return (*hexutil.Big)(s.chain.gasPrice()), nil
+		}
+	}
+	return nil
+}
+
+func (s *Server) head() uint64 {
+	s.mu.Lock()
+	lag := s.headLag
+	s.mu.Unlock()
+	head := s.chain.Head()
+	if lag > head {
+		return 0
+	}
+	return head - lag
+}
+
+// blockNumber decode a block number or tag parameter
+func (s *Server) blockNumber(param string) (uint64, error) {
+	switch param {
+	case "", "latest", "pending", "safe", "finalized":
+		return s.head(), nil
+	case "earliest":
+		return 0, nil
+	}
+	number, err := hexutil.DecodeUint64(param)
+	if err != nil {
+		return 0, &Error{Code: -32602, Message: "invalid block number " + param}
+	}
+	return number, nil
+}
+
+func (s *Server) getBlockByNumber(params []json.RawMessage) (interface{}, error) {
+	var tag string
+	if len(params) == 0 || json.Unmarshal(params[0], &tag) != nil {
+		return nil, &Error{Code: -32602, Message: "missing block number"}
+	}
+	number, err := s.blockNumber(tag)
+	if err != nil {
+		return nil, err
+	}
+	header := s.chain.Header(number)
+	if header == nil || number > s.head() {
+		return nil, nil
+	}
+	data, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	block := map[string]interface{}{}
+	if err := json.Unmarshal(data, &block); err != nil {
+		return nil, err
+	}
+	block["transactions"] = []interface{}{}
+	block["uncles"] = []interface{}{}
+	return block, nil
+}
+
+func (s *Server) getLogs(params []json.RawMessage) (interface{}, error) {
+	var query filterQuery
+	if len(params) == 0 || json.Unmarshal(params[0], &query) != nil {
+		return nil, &Error{Code: -32602, Message: "invalid filter"}
+	}
+	from, err := s.blockNumber(query.FromBlock)
+	if err != nil {
+		return nil, err
+	}
+	to, err := s.blockNumber(query.ToBlock)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	maxLogRange, tamper := s.maxLogRange, s.tamperLogs
+	s.mu.Unlock()
+	if maxLogRange > 0 && to >= from && to-from+1 > maxLogRange {
+		return nil, &Error{
+			Code: ErrCodeRangeTooLarge,
+			Message: fmt.Sprintf(
+				"query returned more than 10000 results. Try with this block range [%s, %s].",
+				hexutil.EncodeUint64(from),
+				hexutil.EncodeUint64(from+maxLogRange-1),
+			),
+		}
+	}
+
+	addresses, err := decodeAddresses(query.Address)
+	if err != nil {
+		return nil, err
+	}
+	topics := make([][]common.Hash, len(query.Topics))
+	for i, raw := range query.Topics {
+		if topics[i], err = decodeTopics(raw); err != nil {
+			return nil, err
+		}
+	}
+	logs := make([]types.Log, 0)
+	for _, log := range s.chain.Logs(from, min(to, s.head())) {
+		if matchLog(log, addresses, topics) {
+			logs = append(logs, log)
+		}
+	}
+	if tamper != nil {
+		logs = tamper(logs)
+	}
+	return logs, nil
+}
+
+func (s *Server) getTransactionReceipt(params []json.RawMessage) (interface{}, error) {
+	var txHash common.Hash
+	if len(params) == 0 || json.Unmarshal(params[0], &txHash) != nil {
+		return nil, &Error{Code: -32602, Message: "invalid transaction hash"}
+	}
+	receipt := s.chain.receipt(txHash)
+	if receipt == nil || receipt.BlockNumber == nil || receipt.BlockNumber.Uint64() > s.head() {
+		return nil, nil
+	}
+	return receipt, nil
+}
+
+func (s *Server) getTransactionByHash(params []json.RawMessage) (interface{}, error) {
+	var txHash common.Hash
+	if len(params) == 0 || json.Unmarshal(params[0], &txHash) != nil {
+		return nil, &Error{Code: -32602, Message: "invalid transaction hash"}
+	}
+	tx, from, ok := s.chain.Transaction(txHash)
+	if !ok {
+		return nil, nil
+	}
+	data, err := tx.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	fields["from"] = from
+	fields["blockHash"], fields["blockNumber"], fields["transactionIndex"] = nil, nil, nil
+	if receipt := s.chain.receipt(txHash); receipt != nil && receipt.BlockNumber.Uint64() <= s.head() {
+		fields["blockHash"] = receipt.BlockHash
+		fields["blockNumber"] = (*hexutil.Big)(receipt.BlockNumber)
+		fields["transactionIndex"] = hexutil.Uint(receipt.TransactionIndex)
+	}
+	return fields, nil
+}
+
+func (s *Server) call(params []json.RawMessage) (interface{}, error) {
+	var msg callMsg
+	if len(params) == 0 || json.Unmarshal(params[0], &msg) != nil {
+		return nil, &Error{Code: -32602, Message: "invalid call"}
+	}
+	data := msg.Input
+	if len(data) == 0 {
+		data = msg.Data
+	}
+	if msg.To == MulticallAddress && bytes.HasPrefix(data, aggregate3.ID) {
+		return s.multicall(data[len(aggregate3.ID):])
+	}
+	if revert, ok := s.chain.revertData(msg.To); ok {
+		reason, _ := abi.UnpackRevert(revert)
+		return nil, &Error{Code: ErrCodeReverted, Message: "execution reverted: " + reason, Data: revert}
+	}
+	result, ok := s.chain.call(msg.To, data)
+	if !ok {
+		return nil, &Error{Code: ErrCodeReverted, Message: "execution reverted"}
+	}
+	return hexutil.Bytes(result), nil
+}
+
+func (s *Server) sendRawTransaction(params []json.RawMessage) (interface{}, error) {
+	var raw hexutil.Bytes
+	if len(params) == 0 || json.Unmarshal(params[0], &raw) != nil {
+		return nil, &Error{Code: -32602, Message: "invalid raw transaction"}
+	}
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(raw); err != nil {
+		return nil, &Error{Code: -32602, Message: "invalid raw transaction: " + err.Error()}
+	}
+	if err := s.chain.sendTransaction(tx); err != nil {
+		return nil, err
+	}
+	return tx.Hash(), nil
+}
+
+func (s *Server) getTransactionCount(params []json.RawMessage) (interface{}, error) {
+	var account common.Address
+	var tag string
+	if len(params) < 2 || json.Unmarshal(params[0], &account) != nil || json.Unmarshal(params[1], &tag) != nil {
+		return nil, &Error{Code: -32602, Message: "invalid transaction count request"}
+	}
+	if tag == "pending" {
+		return hexutil.Uint64(s.chain.pendingNonce(account)), nil
+	}
+	return hexutil.Uint64(s.chain.Nonce(account)), nil
+}
+
+func (s *Server) estimateGas(params []json.RawMessage) (interface{}, error) {
+	var msg callMsg
+	if len(params) == 0 || json.Unmarshal(params[0], &msg) != nil {
+		return nil, &Error{Code: -32602, Message: "invalid call"}
+	}
+	data := msg.Input
+	if len(data) == 0 {
+		data = msg.Data
+	}
+	return hexutil.Uint64(intrinsicGas(data)), nil
+}
+
+// multicall emulate Multicall3 aggregate3 with the scripted calls of the chain
+func (s *Server) multicall(input []byte) (interface{}, error) {
+	args, err := aggregate3.Inputs.Unpack(input)
+	if err != nil {
+		return nil, &Error{Code: ErrCodeReverted, Message: "execution reverted"}
+	}
+	calls := *abi.ConvertType(args[0], new([]multicallCall)).(*[]multicallCall)
+	results := make([]multicallResult, len(calls))
+	for i, call := range calls {
+		result, ok := s.chain.call(call.Target, call.CallData)
+		if !ok && !call.AllowFailure {
+			return nil, &Error{Code: ErrCodeReverted, Message: "execution reverted: Multicall3: call failed"}
+		}
+		results[i] = multicallResult{Success: ok, ReturnData: result}
+	}
+	output, err := aggregate3.Outputs.Pack(results)
+	if err != nil {
+		return nil, err
+	}
+	return hexutil.Bytes(output), nil
+}
+
+func mustType(name string) abi.Type {
+	typ, err := abi.NewType(name, "", nil)
+	if err != nil {
+		panic(err)
+	}
+	return typ
+}
+
+func decodeAddresses(raw json.RawMessage) ([]common.Address, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var addresses []common.Address
+	if raw[0] == '[' {
+		if err := json.Unmarshal(raw, &addresses); err != nil {
+			return nil, &Error{Code: -32602, Message: "invalid address filter"}
+		}
+		return addresses, nil
+	}
+	var address common.Address
+	if err := json.Unmarshal(raw, &address); err != nil {
+		return nil, &Error{Code: -32602, Message: "invalid address filter"}
+	}
+	return []common.Address{address}, nil
+}
+
+func decodeTopics(raw json.RawMessage) ([]common.Hash, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var topics []common.Hash
+	if raw[0] == '[' {
+		if err := json.Unmarshal(raw, &topics); err != nil {
+			return nil, &Error{Code: -32602, Message: "invalid topic filter"}
+		}
+		return topics, nil
+	}
+	var topic common.Hash
+	if err := json.Unmarshal(raw, &topic); err != nil {
+		return nil, &Error{Code: -32602, Message: "invalid topic filter"}
+	}
+	return []common.Hash{topic}, nil
+}
+
+// matchLog apply the eth_getLogs address and positional topic filters, an empty position match any topic
+func matchLog(log types.Log, addresses []common.Address, topics [][]common.Hash) bool {
+	if len(addresses) > 0 && !slices.Contains(addresses, log.Address) {
+		return false
+	}
+	if len(topics) > len(log.Topics) {
+		return false
+	}
+	for i, position := range topics {
+		if len(position) > 0 && !slices.Contains(position, log.Topics[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,204 @@
+package rpctest
+
+import (
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// minedTx is a transaction included in a block, kept to send it back to the pool on a reorg
+type minedTx struct {
+	tx   *types.Transaction
+	from common.Address
+}
+
+var (
+	// DefaultBaseFee is the base fee of the blocks of a new Chain
+	DefaultBaseFee = big.NewInt(params.GWei)
+	// DefaultTip is the priority fee suggested by eth_maxPriorityFeePerGas on a new Chain
+	DefaultTip = big.NewInt(params.GWei)
+)
+
+// SetBaseFee change the base fee of the blocks mined from now on
+func (c *Chain) SetBaseFee(baseFee *big.Int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.baseFee = new(big.Int).Set(baseFee)
+}
+
+// Pending return the transactions sent to the chain and not mined yet
+func (c *Chain) Pending() []*types.Transaction {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var pending []*types.Transaction
+	for _, txs := range c.pending {
+		for _, tx := range txs {
+			pending = append(pending, tx)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Nonce() < pending[j].Nonce() })
+	return pending
+}
+
+// Transaction return a transaction that is pending or mined, with its sender. The block of a mined transaction
+// is in its receipt
+func (c *Chain) Transaction(txHash common.Hash) (*types.Transaction, common.Address, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if mined, ok := c.mined[txHash]; ok {
+		return mined.tx, mined.from, true
+	}
+	for from, txs := range c.pending {
+		for _, tx := range txs {
+			if tx.Hash() == txHash {
+				return tx, from, true
+			}
+		}
+	}
+	return nil, common.Address{}, false
+}
+
+// Nonce return the number of transactions of the account that are mined
+func (c *Chain) Nonce(account common.Address) uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.nonces[account]
+}
+
+// sendTransaction add a signed transaction to the pool, with the errors answered by geth
+func (c *Chain) sendTransaction(tx *types.Transaction) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	from, err := types.Sender(types.LatestSignerForChainID(new(big.Int).SetUint64(c.chainID)), tx)
+	if err != nil {
+		return &Error{Code: -32000, Message: "invalid sender"}
+	}
+	if tx.Nonce() < c.nonces[from] {
+		return &Error{Code: -32000, Message: "nonce too low"}
+	}
+	if existing := c.pending[from][tx.Nonce()]; existing != nil {
+		if existing.Hash() == tx.Hash() {
+			return &Error{Code: -32000, Message: "already known"}
+		}
+		if !bumped(existing, tx) {
+			return &Error{Code: -32000, Message: "replacement transaction underpriced"}
+		}
+	}
+	if c.pending[from] == nil {
+		c.pending[from] = map[uint64]*types.Transaction{}
+	}
+	c.pending[from][tx.Nonce()] = tx
+	return nil
+}
+
+// pendingNonce return the nonce following the transactions of the account that are mined or pending in sequence
+func (c *Chain) pendingNonce(account common.Address) uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	nonce := c.nonces[account]
+	for c.pending[account][nonce] != nil {
+		nonce++
+	}
+	return nonce
+}
+
+func (c *Chain) suggestedTip() *big.Int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return new(big.Int).Set(c.tip)
+}
+
+func (c *Chain) gasPrice() *big.Int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return new(big.Int).Add(c.baseFee, c.tip)
+}
+
+// include mine the executable pending transactions in the header, c.mu must be held
+func (c *Chain) include(header *types.Header) {
+	senders := make([]common.Address, 0, len(c.pending))
+	for from := range c.pending {
+		senders = append(senders, from)
+	}
+	sort.Slice(senders, func(i, j int) bool { return senders[i].Cmp(senders[j]) < 0 })
+	var cumulativeGas uint64
+	var index uint
+	for _, from := range senders {
+		txs := c.pending[from]
+		for {
+			tx := txs[c.nonces[from]]
+			if tx == nil || tx.GasFeeCap().Cmp(header.BaseFee) < 0 {
+				break
+			}
+			gasUsed := min(intrinsicGas(tx.Data()), tx.Gas())
+			cumulativeGas += gasUsed
+			effectiveGasPrice := new(big.Int).Add(header.BaseFee, tx.EffectiveGasTipValue(header.BaseFee))
+			status := types.ReceiptStatusSuccessful
+			if tx.To() != nil && c.reverts[*tx.To()] != nil {
+				status = types.ReceiptStatusFailed
+			}
+			c.receipts[tx.Hash()] = &types.Receipt{
+				Type:              tx.Type(),
+				Status:            status,
+				CumulativeGasUsed: cumulativeGas,
+				Logs:              []*types.Log{},
+				TxHash:            tx.Hash(),
+				GasUsed:           gasUsed,
+				EffectiveGasPrice: effectiveGasPrice,
+				BlockHash:         header.Hash(),
+				BlockNumber:       new(big.Int).Set(header.Number),
+				TransactionIndex:  index,
+			}
+			c.mined[tx.Hash()] = minedTx{tx: tx, from: from}
+			c.nonces[from]++
+			index++
+		}
+		// transactions replaced by the mined ones are dropped
+		for nonce := range txs {
+			if nonce < c.nonces[from] {
+				delete(txs, nonce)
+			}
+		}
+		if len(txs) == 0 {
+			delete(c.pending, from)
+		}
+	}
+}
+
+// unmine send the transactions of the blocks from the given number back to the pool, c.mu must be held
+func (c *Chain) unmine(from uint64) {
+	for txHash, receipt := range c.receipts {
+		if receipt.BlockNumber == nil || receipt.BlockNumber.Uint64() < from {
+			continue
+		}
+		delete(c.receipts, txHash)
+		mined, ok := c.mined[txHash]
+		if !ok {
+			continue
+		}
+		delete(c.mined, txHash)
+		if c.pending[mined.from] == nil {
+			c.pending[mined.from] = map[uint64]*types.Transaction{}
+		}
+		c.pending[mined.from][mined.tx.Nonce()] = mined.tx
+		c.nonces[mined.from] = min(c.nonces[mined.from], mined.tx.Nonce())
+	}
+}
+
+// bumped report whether the replacement raise both fee caps by at least 10%, like geth require
+func bumped(existing, replacement *types.Transaction) bool {
+	minimum := func(fee *big.Int) *big.Int {
+		bumped := new(big.Int).Mul(fee, big.NewInt(110))
+		return bumped.Div(bumped, big.NewInt(100))
+	}
+	return replacement.GasTipCap().Cmp(minimum(existing.GasTipCap())) >= 0 &&
+		replacement.GasFeeCap().Cmp(minimum(existing.GasFeeCap())) >= 0
+}
+
+// intrinsicGas is the gas used by every transaction, a plain transfer plus its call data
+func intrinsicGas(data []byte) uint64 {
+	return params.TxGas + uint64(len(data))*params.TxDataNonZeroGasEIP2028
+}
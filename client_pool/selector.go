@@ -0,0 +1,226 @@
+package client_pool
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/duongtuttbn/toolkit/log"
+	"github.com/pkg/errors"
+)
+
+type (
+	// Selector choose which of the available clients serve the next request
+	Selector interface {
+		// Select return one of the given available clients, candidates is never empty
+		Select(candidates []*Client) *Client
+	}
+
+	// RoundRobinSelector hand out the available clients one after another, in pool order
+	RoundRobinSelector struct {
+		mu      sync.Mutex
+		counter int
+	}
+
+	// WeightedSelector hand out the available clients proportionally to their weight,
+	// using the smooth weighted round-robin algorithm
+	WeightedSelector struct {
+		mu      sync.Mutex
+		current map[*Client]int
+	}
+
+	// LeastInFlightSelector hand out the available client with the fewest requests in flight
+	LeastInFlightSelector struct {
+		mu      sync.Mutex
+		counter int
+	}
+
+	// LatencySelector compare two random available clients and hand out the one with
+	// the lowest latency EWMA weighted by its requests in flight
+	LatencySelector struct{}
+)
+
+const (
+	StrategyRoundRobin    = "round_robin"
+	StrategyWeighted      = "weighted"
+	StrategyLeastInFlight = "least_in_flight"
+	StrategyLatency       = "latency"
+)
+
+// latencyDecay is the weight of the newest sample in the latency EWMA
+const latencyDecay = 0.2
+
+// newSelector build the selector described by the config, round-robin by default
+func newSelector(cfg Config) (Selector, error) {
+	if cfg.Selector != nil {
+		return cfg.Selector, nil
+	}
+	switch cfg.Strategy {
+	case "", StrategyRoundRobin:
+		return &RoundRobinSelector{}, nil
+	case StrategyWeighted:
+		return &WeightedSelector{}, nil
+	case StrategyLeastInFlight:
+		return &LeastInFlightSelector{}, nil
+	case StrategyLatency:
+		return &LatencySelector{}, nil
+	default:
+		return nil, errors.Errorf("unknown client selection strategy: %s", cfg.Strategy)
+	}
+}
+
+func (s *RoundRobinSelector) Select(candidates []*Client) *Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// pick the first candidate at or after the counter position in the pool, wrapping around
+	var next, first *Client
+	for _, client := range candidates {
+		if client.index >= s.counter && (next == nil || client.index < next.index) {
+			next = client
+		}
+		if first == nil || client.index < first.index {
+			first = client
+		}
+	}
+	if next == nil {
+		next = first
+	}
+	s.counter = next.index + 1
+	return next
+}
+
+func (s *WeightedSelector) Select(candidates []*Client) *Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current == nil {
+		s.current = make(map[*Client]int)
+	}
+	var best *Client
+	total := 0
+	for _, client := range candidates {
+		weight := client.Weight()
+		total += weight
+		s.current[client] += weight
+		if best == nil || s.current[client] > s.current[best] {
+			best = client
+		}
+	}
+	s.current[best] -= total
+	return best
+}
+
+func (s *LeastInFlightSelector) Select(candidates []*Client) *Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// start from a rotating offset so ties are spread over the clients
+	s.counter++
+	var best *Client
+	for i := range candidates {
+		client := candidates[(s.counter+i)%len(candidates)]
+		if best == nil || client.InFlight() < best.InFlight() {
+			best = client
+		}
+	}
+	return best
+}
+
+func (s *LatencySelector) Select(candidates []*Client) *Client {
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+	i := rand.IntN(len(candidates))
+	j := rand.IntN(len(candidates) - 1)
+	if j >= i {
+		j++
+	}
+	if latencyScore(candidates[j]) < latencyScore(candidates[i]) {
+		return candidates[j]
+	}
+	return candidates[i]
+}
+
+// latencyScore is the expected time to serve one more request on the client.
+// Clients without any sample score zero so they get tried
+func latencyScore(client *Client) float64 {
+	return float64(client.Latency()) * float64(client.InFlight()+1)
+}
+
+// call run a single request against client once its rate limit allows it,
+// keeping its in-flight and latency statistics up to date and tracing it as an attempt
+func call[T any](ctx context.Context, client *Client, fn func(ctx context.Context) (T, error)) (T, error) {
+	return callN(ctx, client, 1, fn)
+}
+
+// callN is call for a request that count as several against the rate limit, like a JSON-RPC batch
+func callN[T any](ctx context.Context, client *Client, requests int, fn func(ctx context.Context) (T, error)) (T, error) {
+	ctx, span := client.startAttempt(ctx)
+	if err := client.waitBudget(ctx, requests); err != nil {
+		endAttempt(span, err, ClassUnknown)
+		var zero T
+		return zero, err
+	}
+	client.inFlight.Add(1)
+	start := time.Now()
+	result, err := fn(ctx)
+	client.inFlight.Add(-1)
+	// transport errors embed the endpoint URL, along with the API key it may hold
+	err = client.redactError(err)
+	// requests cancelled by the caller, like the losing side of a hedge, say nothing about the endpoint
+	if ctx.Err() != nil {
+		client.metrics.observeRequest(0, ClassUnknown, false)
+		endAttempt(span, ctx.Err(), ClassUnknown)
+		return result, err
+	}
+	latency := time.Since(start)
+	client.observeLatency(latency)
+	var class ErrorClass
+	if err != nil {
+		class = client.classifyError(err)
+	}
+	client.metrics.observeRequest(latency, class, err != nil)
+	endAttempt(span, err, class)
+	return result, err
+}
+
+// retry run fn on an available client until it succeeds, ctx is done or it returns an error rejected by retryable.
+// Clients whose request failed with a retryable error are marked as failed
+func retry[T any](
+	ctx context.Context,
+	pool *ClientPool,
+	method string,
+	retryable func(error) bool,
+	fn func(ctx context.Context, client *Client) (T, error),
+) (T, error) {
+	ctx = withMethod(ctx, method)
+	for {
+		client, err := pool.GetClientContext(ctx)
+		if err != nil {
+			var zero T
+			return zero, err
+		}
+		result, err := call(ctx, client, func(ctx context.Context) (T, error) {
+			return fn(ctx, client)
+		})
+		if err != nil {
+			if retryable(err) && ctx.Err() == nil {
+				client.MarkError(err)
+				log.FromContext(ctx).Errorf("%s on endpoint %s error: %v", method, client.Label(), err)
+				continue
+			}
+			return result, err
+		}
+		client.MarkSuccess()
+		return result, nil
+	}
+}
+
+func removeClient(clients []*Client, client *Client) []*Client {
+	remaining := make([]*Client, 0, len(clients))
+	for _, c := range clients {
+		if c != client {
+			remaining = append(remaining, c)
+		}
+	}
+	return remaining
+}
@@ -0,0 +1,94 @@
+package client_pool
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func testClients(n int) []*Client {
+	clients := make([]*Client, n)
+	for i := range clients {
+		clients[i] = &Client{index: i}
+	}
+	return clients
+}
+
+// picks return the pool indexes of the clients handed out by n selections
+func picks(selector Selector, candidates []*Client, n int) []int {
+	indexes := make([]int, n)
+	for i := range indexes {
+		indexes[i] = selector.Select(candidates).index
+	}
+	return indexes
+}
+
+func TestRoundRobinSelector(t *testing.T) {
+	clients := testClients(3)
+	selector := &RoundRobinSelector{}
+	if got := picks(selector, clients, 6); !slices.Equal(got, []int{0, 1, 2, 0, 1, 2}) {
+		t.Fatalf("got %v, want the clients in pool order", got)
+	}
+	// an unavailable client is skipped without breaking the rotation of the others
+	if got := picks(selector, []*Client{clients[0], clients[2]}, 4); !slices.Equal(got, []int{0, 2, 0, 2}) {
+		t.Fatalf("got %v without client 1, want 0 and 2 in turn", got)
+	}
+}
+
+func TestWeightedSelector(t *testing.T) {
+	clients := testClients(3)
+	clients[0].SetWeight(5)
+	selector := &WeightedSelector{}
+	// smooth weighted round-robin interleave the light clients instead of serving the heavy one 5 times in a row
+	if got := picks(selector, clients, 7); !slices.Equal(got, []int{0, 0, 1, 0, 2, 0, 0}) {
+		t.Fatalf("got %v, want the smooth weighted order", got)
+	}
+	counts := make(map[int]int)
+	for _, index := range picks(selector, clients, 700) {
+		counts[index]++
+	}
+	if counts[0] != 500 || counts[1] != 100 || counts[2] != 100 {
+		t.Fatalf("got distribution %v, want 5:1:1", counts)
+	}
+}
+
+func TestLeastInFlightSelector(t *testing.T) {
+	clients := testClients(3)
+	selector := &LeastInFlightSelector{}
+	counts := make(map[int]int)
+	for _, index := range picks(selector, clients, 300) {
+		counts[index]++
+	}
+	if counts[0] != 100 || counts[1] != 100 || counts[2] != 100 {
+		t.Fatalf("got distribution %v of idle clients, want ties spread evenly", counts)
+	}
+
+	clients[0].inFlight.Store(2)
+	clients[2].inFlight.Store(1)
+	if got := picks(selector, clients, 3); !slices.Equal(got, []int{1, 1, 1}) {
+		t.Fatalf("got %v, want the client without requests in flight", got)
+	}
+}
+
+func TestLatencySelector(t *testing.T) {
+	clients := testClients(3)
+	clients[0].observeLatency(10 * time.Millisecond)
+	clients[1].observeLatency(100 * time.Millisecond)
+	clients[2].observeLatency(time.Second)
+	selector := &LatencySelector{}
+	counts := make(map[int]int)
+	for _, index := range picks(selector, clients, 3000) {
+		counts[index]++
+	}
+	// the slowest client lose every comparison, the fastest win the two thirds of them it takes part in
+	if counts[2] != 0 || counts[0] < 1800 || counts[1] < 700 {
+		t.Fatalf("got distribution %v, want the clients ordered by latency", counts)
+	}
+
+	// requests in flight weigh on the latency, the fast client is skipped once it is busy enough
+	fast, slow := clients[0], clients[1]
+	fast.inFlight.Store(20)
+	if got := picks(selector, []*Client{fast, slow}, 10); !slices.Equal(got, []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1}) {
+		t.Fatalf("got %v, want the idle slow client over the busy fast one", got)
+	}
+}
@@ -0,0 +1,468 @@
+package client_pool
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/duongtuttbn/toolkit/log"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/pkg/errors"
+)
+
+type (
+	SenderConfig struct {
+		// BroadcastEndpoints is the number of endpoints a transaction is sent to at once, default to 3
+		BroadcastEndpoints int `json:"broadcast_endpoints" yaml:"broadcast_endpoints"`
+		// GasLimitMultiplier scale the estimated gas limit, default to 1.2
+		GasLimitMultiplier float64 `json:"gas_limit_multiplier" yaml:"gas_limit_multiplier"`
+		// BaseFeeMultiplier scale the latest base fee in the max fee per gas, so the transaction stay
+		// executable while the base fee rise, default to 2
+		BaseFeeMultiplier float64 `json:"base_fee_multiplier" yaml:"base_fee_multiplier"`
+		// MaxFeePerGas cap the fees of the transactions and of their replacements, in wei, no cap when nil
+		MaxFeePerGas *big.Int `json:"max_fee_per_gas" yaml:"max_fee_per_gas"`
+		// BumpPercent is the fee increase of a replacement, default to 12 as most nodes require at least 10
+		BumpPercent int `json:"bump_percent" yaml:"bump_percent"`
+		// PollInterval is the time between two receipt requests, default to 2s
+		PollInterval time.Duration `json:"poll_interval" yaml:"poll_interval"`
+		// StuckAfter is how long SendAndWait wait for a receipt before speeding the transaction up,
+		// zero never speed it up
+		StuckAfter time.Duration `json:"stuck_after" yaml:"stuck_after"`
+		// MaxBumps is the number of replacements SendAndWait send for a stuck transaction, default to 3
+		MaxBumps int `json:"max_bumps" yaml:"max_bumps"`
+	}
+
+	// TxRequest describe a transaction to send
+	TxRequest struct {
+		// To is the recipient, nil deploy a contract
+		To    *common.Address
+		Value *big.Int
+		Data  []byte
+		// Gas is the gas limit, estimated when zero
+		Gas uint64
+	}
+
+	// Sender sign and broadcast transactions through a ClientPool. Nonces are tracked per account by the pool,
+	// so the senders of the same account on the same pool never use a nonce twice
+	Sender struct {
+		pool   *ClientPool
+		signer Signer
+		config SenderConfig
+	}
+
+	// txFees are the fees of a dynamic fee transaction, or the gas price of a legacy one when tipCap is nil
+	txFees struct {
+		gasPrice *big.Int
+		tipCap   *big.Int
+		feeCap   *big.Int
+	}
+)
+
+const (
+	defaultBroadcastEndpoints = 3
+	defaultGasLimitMultiplier = 1.2
+	defaultBaseFeeMultiplier  = 2
+	defaultBumpPercent        = 12
+	defaultPollInterval       = 2 * time.Second
+	defaultMaxBumps           = 3
+	// maxNonceRetries is the number of times Send sync the nonce and sign again when it is too low
+	maxNonceRetries = 3
+)
+
+// ErrFeeCapExceeded is returned when a replacement would need fees above SenderConfig.MaxFeePerGas
+var ErrFeeCapExceeded = errors.New("replacement fees exceed the max fee per gas")
+
+func NewSender(pool *ClientPool, signer Signer, cfg SenderConfig) *Sender {
+	if cfg.BroadcastEndpoints <= 0 {
+		cfg.BroadcastEndpoints = defaultBroadcastEndpoints
+	}
+	if cfg.GasLimitMultiplier <= 0 {
+		cfg.GasLimitMultiplier = defaultGasLimitMultiplier
+	}
+	if cfg.BaseFeeMultiplier <= 0 {
+		cfg.BaseFeeMultiplier = defaultBaseFeeMultiplier
+	}
+	if cfg.BumpPercent <= 0 {
+		cfg.BumpPercent = defaultBumpPercent
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultPollInterval
+	}
+	if cfg.MaxBumps <= 0 {
+		cfg.MaxBumps = defaultMaxBumps
+	}
+	return &Sender{pool: pool, signer: signer, config: cfg}
+}
+
+// Address return the account the transactions are sent from
+func (s *Sender) Address() common.Address {
+	return s.signer.Address()
+}
+
+// ResetNonce forget the locally tracked nonce, the next transaction use the pending nonce of the chain.
+// It is needed when transactions of the account are sent outside of the pool
+func (s *Sender) ResetNonce() {
+	s.pool.nonces.reset(s.signer.Address())
+}
+
+// Send sign the transaction with the next nonce of the account and broadcast it. Fees are estimated from the
+// latest base fee and the suggested priority fee, or from the gas price on chains without EIP-1559.
+// When the nonce is too low, because transactions were sent outside of the pool, it is synced and the
+// transaction signed again
+func (s *Sender) Send(ctx context.Context, req TxRequest) (tx *types.Transaction, err error) {
+	ctx, end := s.pool.startSpan(ctx, "Send")
+	defer func() { end(err) }()
+	chainID, err := s.chainID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	fees, err := s.suggestFees(ctx)
+	if err != nil {
+		return nil, err
+	}
+	fees = s.capFees(fees)
+	if req.Gas == 0 {
+		if req.Gas, err = s.estimateGas(ctx, req); err != nil {
+			return nil, err
+		}
+	}
+
+	account := s.signer.Address()
+	for attempt := 0; ; attempt++ {
+		nonce, err := s.pool.nonces.reserve(ctx, s.pool, account)
+		if err != nil {
+			return nil, err
+		}
+		tx, err = s.signer.SignTx(ctx, newTx(chainID, nonce, req, fees), chainID)
+		if err != nil {
+			s.pool.nonces.release(account, nonce)
+			return nil, errors.Wrap(err, "unable to sign transaction")
+		}
+		err = s.broadcast(ctx, tx)
+		if err == nil {
+			s.pool.nonces.done(account, nonce)
+			return tx, nil
+		}
+		if s.pool.Classify(err) == ClassNonceTooLow && attempt < maxNonceRetries {
+			log.FromContext(ctx).Infof("nonce %d of %s is too low, sync it with the chain", nonce, account)
+			s.pool.nonces.done(account, nonce)
+			s.pool.nonces.reset(account)
+			continue
+		}
+		s.pool.nonces.release(account, nonce)
+		return nil, err
+	}
+}
+
+// SendAndWait send the transaction and wait until it is mined. If it is still pending after StuckAfter,
+// it is sped up, at most MaxBumps times or until MaxFeePerGas, and the receipt of whichever version
+// is mined is returned
+func (s *Sender) SendAndWait(ctx context.Context, req TxRequest) (*types.Receipt, error) {
+	tx, err := s.Send(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	txs := []*types.Transaction{tx}
+	bumps := 0
+	for {
+		waitCtx, cancel := ctx, context.CancelFunc(func() {})
+		if s.config.StuckAfter > 0 && bumps < s.config.MaxBumps {
+			waitCtx, cancel = context.WithTimeout(ctx, s.config.StuckAfter)
+		}
+		receipt, err := s.Wait(waitCtx, txs...)
+		stuck := err != nil && waitCtx.Err() != nil && ctx.Err() == nil
+		cancel()
+		if !stuck {
+			return receipt, err
+		}
+		replacement, err := s.SpeedUp(ctx, txs[len(txs)-1])
+		switch {
+		case err == nil:
+			txs = append(txs, replacement)
+			bumps++
+		case s.pool.Classify(err) == ClassNonceTooLow, errors.Is(err, ErrFeeCapExceeded):
+			// one of the versions was mined meanwhile, or it cannot be bumped anymore, keep waiting
+			log.FromContext(ctx).Infof("stop speeding up transaction %s: %v", tx.Hash(), err)
+			bumps = s.config.MaxBumps
+		default:
+			return nil, err
+		}
+	}
+}
+
+// Wait poll the receipts of the transactions with GetTransactionReceipt until one of them is mined.
+// The transactions are usually a transaction and its replacements, which share a nonce
+func (s *Sender) Wait(ctx context.Context, txs ...*types.Transaction) (*types.Receipt, error) {
+	ticker := time.NewTicker(s.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		for _, tx := range txs {
+			receipt, err := s.pool.GetTransactionReceiptContext(ctx, tx.Hash())
+			if err == nil {
+				return receipt, nil
+			}
+			if !errors.Is(err, ethereum.NotFound) {
+				return nil, err
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// SpeedUp replace a pending transaction by the same one with fees raised by BumpPercent,
+// or to the current suggestion if it is higher
+func (s *Sender) SpeedUp(ctx context.Context, tx *types.Transaction) (replacement *types.Transaction, err error) {
+	ctx, end := s.pool.startSpan(ctx, "SpeedUp")
+	defer func() { end(err) }()
+	return s.replace(ctx, tx, TxRequest{To: tx.To(), Value: tx.Value(), Data: tx.Data(), Gas: tx.Gas()})
+}
+
+// Cancel replace a pending transaction by an empty transfer to the sender itself, with fees raised like SpeedUp
+func (s *Sender) Cancel(ctx context.Context, tx *types.Transaction) (replacement *types.Transaction, err error) {
+	ctx, end := s.pool.startSpan(ctx, "Cancel")
+	defer func() { end(err) }()
+	account := s.signer.Address()
+	return s.replace(ctx, tx, TxRequest{To: &account, Value: new(big.Int), Gas: params.TxGas})
+}
+
+// replace sign and broadcast req with the nonce of tx and bumped fees. It fails with the nonce_too_low class
+// when a transaction of the nonce is already mined
+func (s *Sender) replace(ctx context.Context, tx *types.Transaction, req TxRequest) (*types.Transaction, error) {
+	chainID, err := s.chainID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	fees, err := s.bumpFees(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	replacement, err := s.signer.SignTx(ctx, newTx(chainID, tx.Nonce(), req, fees), chainID)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to sign transaction")
+	}
+	if err := s.broadcast(ctx, replacement); err != nil {
+		return nil, err
+	}
+	return replacement, nil
+}
+
+// broadcast send the signed transaction to up to BroadcastEndpoints endpoints at once. It succeeds as soon as one
+// of them accepted the transaction or already knew it. When they all failed with a retryable error,
+// the transaction is sent again to other endpoints
+func (s *Sender) broadcast(ctx context.Context, tx *types.Transaction) error {
+	ctx = withMethod(ctx, "eth_sendRawTransaction")
+	for {
+		clients, err := s.broadcastClients(ctx)
+		if err != nil {
+			return err
+		}
+		errs := make([]error, len(clients))
+		var wg sync.WaitGroup
+		for i, client := range clients {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, errs[i] = call(ctx, client, func(ctx context.Context) (struct{}, error) {
+					return struct{}{}, client.SendTransaction(ctx, tx)
+				})
+			}()
+		}
+		wg.Wait()
+
+		accepted := false
+		for _, err := range errs {
+			if err == nil || s.pool.Classify(err) == ClassAlreadyKnown {
+				accepted = true
+			}
+		}
+		if !accepted && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var rejected error
+		for i, client := range clients {
+			switch err := errs[i]; {
+			case err == nil || s.pool.Classify(err) == ClassAlreadyKnown:
+				client.MarkSuccess()
+			case s.pool.retryable(err):
+				client.MarkError(err)
+				log.FromContext(ctx).Errorf("send transaction %s on endpoint %s error: %v", tx.Hash(), client.Label(), err)
+			case rejected == nil:
+				// the endpoint is fine, the transaction is not
+				rejected = err
+			}
+		}
+		if accepted {
+			return nil
+		}
+		if rejected != nil {
+			return errors.Wrapf(rejected, "transaction %s rejected", tx.Hash())
+		}
+	}
+}
+
+// broadcastClients return up to BroadcastEndpoints available clients, waiting for one if none is available
+func (s *Sender) broadcastClients(ctx context.Context) ([]*Client, error) {
+	for n := min(s.config.BroadcastEndpoints, len(s.pool.clients)); n > 0; n-- {
+		if clients, _, _ := s.pool.nextClients(n); clients != nil {
+			return clients, nil
+		}
+	}
+	client, err := s.pool.GetClientContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return []*Client{client}, nil
+}
+
+// chainID return the chain of the pool, asking an endpoint when the pool does not know it
+func (s *Sender) chainID(ctx context.Context) (*big.Int, error) {
+	if chainID := s.pool.ChainID(); chainID != 0 {
+		return new(big.Int).SetUint64(chainID), nil
+	}
+	return retry(ctx, s.pool, "eth_chainId", s.pool.retryable, func(ctx context.Context, client *Client) (*big.Int, error) {
+		return client.Client.ChainID(ctx)
+	})
+}
+
+// suggestFees return the fees of a transaction sent now, without the MaxFeePerGas cap
+func (s *Sender) suggestFees(ctx context.Context) (txFees, error) {
+	header, err := retry(ctx, s.pool, "eth_getBlockByNumber", s.pool.retryable, func(ctx context.Context, client *Client) (*types.Header, error) {
+		return client.HeaderByNumber(ctx, nil)
+	})
+	if err != nil {
+		return txFees{}, errors.Wrap(err, "unable to get latest header")
+	}
+	if header.BaseFee == nil {
+		gasPrice, err := retry(ctx, s.pool, "eth_gasPrice", s.pool.retryable, func(ctx context.Context, client *Client) (*big.Int, error) {
+			return client.SuggestGasPrice(ctx)
+		})
+		if err != nil {
+			return txFees{}, errors.Wrap(err, "unable to get gas price")
+		}
+		return txFees{gasPrice: gasPrice}, nil
+	}
+	tipCap, err := retry(ctx, s.pool, "eth_maxPriorityFeePerGas", s.pool.retryable, func(ctx context.Context, client *Client) (*big.Int, error) {
+		return client.SuggestGasTipCap(ctx)
+	})
+	if err != nil {
+		return txFees{}, errors.Wrap(err, "unable to get priority fee")
+	}
+	feeCap := scale(header.BaseFee, s.config.BaseFeeMultiplier)
+	return txFees{tipCap: tipCap, feeCap: feeCap.Add(feeCap, tipCap)}, nil
+}
+
+// capFees lower the fees to MaxFeePerGas
+func (s *Sender) capFees(fees txFees) txFees {
+	maxFee := s.config.MaxFeePerGas
+	if maxFee == nil {
+		return fees
+	}
+	if fees.tipCap == nil {
+		return txFees{gasPrice: bigMin(fees.gasPrice, maxFee)}
+	}
+	feeCap := bigMin(fees.feeCap, maxFee)
+	return txFees{tipCap: bigMin(fees.tipCap, feeCap), feeCap: feeCap}
+}
+
+// bumpFees return the fees of a replacement of tx: its fees raised by BumpPercent, or the current suggestion
+// if it is higher. The replacement keep the type of tx
+func (s *Sender) bumpFees(ctx context.Context, tx *types.Transaction) (txFees, error) {
+	suggested, err := s.suggestFees(ctx)
+	if err != nil {
+		return txFees{}, err
+	}
+	var fees txFees
+	if tx.Type() == types.LegacyTxType {
+		suggestedPrice := suggested.gasPrice
+		if suggestedPrice == nil {
+			suggestedPrice = suggested.feeCap
+		}
+		fees = txFees{gasPrice: bigMax(s.bump(tx.GasPrice()), suggestedPrice)}
+	} else {
+		suggestedTip, suggestedFeeCap := suggested.tipCap, suggested.feeCap
+		if suggestedTip == nil {
+			suggestedTip, suggestedFeeCap = suggested.gasPrice, suggested.gasPrice
+		}
+		fees = txFees{
+			tipCap: bigMax(s.bump(tx.GasTipCap()), suggestedTip),
+			feeCap: bigMax(s.bump(tx.GasFeeCap()), suggestedFeeCap),
+		}
+	}
+	if maxFee := s.config.MaxFeePerGas; maxFee != nil && s.bump(tx.GasFeeCap()).Cmp(maxFee) > 0 {
+		return txFees{}, ErrFeeCapExceeded
+	}
+	return s.capFees(fees), nil
+}
+
+// bump raise the fee by BumpPercent, rounded up
+func (s *Sender) bump(fee *big.Int) *big.Int {
+	bumped := new(big.Int).Mul(fee, big.NewInt(int64(100+s.config.BumpPercent)))
+	bumped.Add(bumped, big.NewInt(99))
+	return bumped.Div(bumped, big.NewInt(100))
+}
+
+func (s *Sender) estimateGas(ctx context.Context, req TxRequest) (uint64, error) {
+	msg := ethereum.CallMsg{From: s.signer.Address(), To: req.To, Value: req.Value, Data: req.Data}
+	gas, err := retry(ctx, s.pool, "eth_estimateGas", s.pool.retryable, func(ctx context.Context, client *Client) (uint64, error) {
+		return client.EstimateGas(ctx, msg)
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to estimate gas")
+	}
+	return scale(new(big.Int).SetUint64(gas), s.config.GasLimitMultiplier).Uint64(), nil
+}
+
+func newTx(chainID *big.Int, nonce uint64, req TxRequest, fees txFees) *types.Transaction {
+	value := req.Value
+	if value == nil {
+		value = new(big.Int)
+	}
+	if fees.tipCap == nil {
+		return types.NewTx(&types.LegacyTx{
+			Nonce:    nonce,
+			GasPrice: fees.gasPrice,
+			Gas:      req.Gas,
+			To:       req.To,
+			Value:    value,
+			Data:     req.Data,
+		})
+	}
+	return types.NewTx(&types.DynamicFeeTx{
+		ChainID:   chainID,
+		Nonce:     nonce,
+		GasTipCap: fees.tipCap,
+		GasFeeCap: fees.feeCap,
+		Gas:       req.Gas,
+		To:        req.To,
+		Value:     value,
+		Data:      req.Data,
+	})
+}
+
+// scale multiply x by factor, rounded down
+func scale(x *big.Int, factor float64) *big.Int {
+	scaled, _ := new(big.Float).Mul(new(big.Float).SetInt(x), big.NewFloat(factor)).Int(nil)
+	return scaled
+}
+
+func bigMin(x, y *big.Int) *big.Int {
+	if x.Cmp(y) < 0 {
+		return x
+	}
+	return y
+}
+
+func bigMax(x, y *big.Int) *big.Int {
+	if x.Cmp(y) > 0 {
+		return x
+	}
+	return y
+}
@@ -0,0 +1,103 @@
+package client_pool_test
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/duongtuttbn/toolkit/client_pool"
+	"github.com/duongtuttbn/toolkit/client_pool/rpctest"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func newSender(t *testing.T, pool *client_pool.ClientPool, cfg client_pool.SenderConfig) *client_pool.Sender {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client_pool.NewSender(pool, client_pool.NewPrivateKeySigner(key), cfg)
+}
+
+func TestSenderSpeedUpAndCancel(t *testing.T) {
+	chain := rpctest.NewChain(100)
+	first, second := newServer(t, chain), newServer(t, chain)
+	pool := newPool(t, client_pool.Config{}, first, second)
+	sender := newSender(t, pool, client_pool.SenderConfig{PollInterval: 10 * time.Millisecond})
+	recipient := common.HexToAddress("0x2")
+
+	ctx := testContext(t)
+	transfer, err := sender.Send(ctx, client_pool.TxRequest{To: &recipient, Value: big.NewInt(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stuck, err := sender.Send(ctx, client_pool.TxRequest{To: &recipient, Value: big.NewInt(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transfer.Nonce() != 0 || stuck.Nonce() != 1 {
+		t.Fatalf("got nonces %d and %d, want 0 and 1", transfer.Nonce(), stuck.Nonce())
+	}
+	// both endpoints got the transactions, the second one answered that it already knew them
+	if first.Requests("eth_sendRawTransaction") != 2 || second.Requests("eth_sendRawTransaction") != 2 {
+		t.Fatalf("transactions were not broadcast to both endpoints")
+	}
+
+	spedUp, err := sender.SpeedUp(ctx, stuck)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spedUp.Nonce() != stuck.Nonce() || spedUp.GasTipCap().Cmp(stuck.GasTipCap()) <= 0 {
+		t.Fatalf("speed up is not a replacement with a higher tip: %+v", spedUp)
+	}
+	cancelled, err := sender.Cancel(ctx, spedUp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *cancelled.To() != sender.Address() || cancelled.Value().Sign() != 0 {
+		t.Fatalf("cancel is not an empty transfer to the sender: %+v", cancelled)
+	}
+	if _, err := sender.SpeedUp(ctx, stuck); client_pool.Classify(err) != client_pool.ClassUnderpriced {
+		t.Fatalf("got error %v, want an underpriced replacement", err)
+	}
+
+	chain.Mine(1)
+	receipt, err := sender.Wait(ctx, stuck, spedUp, cancelled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.TxHash != cancelled.Hash() {
+		t.Fatalf("mined %s, want the cancel transaction %s", receipt.TxHash, cancelled.Hash())
+	}
+	if chain.Nonce(sender.Address()) != 2 {
+		t.Fatalf("account nonce is %d, want 2", chain.Nonce(sender.Address()))
+	}
+}
+
+func TestSendAndWaitSpeedsUpStuckTransaction(t *testing.T) {
+	chain := rpctest.NewChain(100)
+	pool := newPool(t, client_pool.Config{}, newServer(t, chain))
+	sender := newSender(t, pool, client_pool.SenderConfig{PollInterval: 10 * time.Millisecond, StuckAfter: 50 * time.Millisecond})
+	recipient := common.HexToAddress("0x2")
+
+	ctx := testContext(t)
+	// a miner that only include the transaction once its tip was raised
+	go func() {
+		for ctx.Err() == nil {
+			if pending := chain.Pending(); len(pending) == 1 && pending[0].GasTipCap().Cmp(rpctest.DefaultTip) > 0 {
+				chain.Mine(1)
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	receipt, err := sender.SendAndWait(ctx, client_pool.TxRequest{To: &recipient, Value: big.NewInt(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful || receipt.EffectiveGasPrice.Cmp(rpctest.DefaultBaseFee) <= 0 {
+		t.Fatalf("unexpected receipt: %+v", receipt)
+	}
+}
@@ -0,0 +1,50 @@
+package client_pool
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pkg/errors"
+)
+
+type (
+	// Signer sign the transactions sent by a Sender, it may be backed by a key in memory, a KMS or a wallet
+	Signer interface {
+		// Address is the account the transactions are sent from
+		Address() common.Address
+		// SignTx return the transaction signed for the chain
+		SignTx(ctx context.Context, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error)
+	}
+
+	// PrivateKeySigner sign with a private key held in memory
+	PrivateKeySigner struct {
+		key     *ecdsa.PrivateKey
+		address common.Address
+	}
+)
+
+func NewPrivateKeySigner(key *ecdsa.PrivateKey) *PrivateKeySigner {
+	return &PrivateKeySigner{key: key, address: crypto.PubkeyToAddress(key.PublicKey)}
+}
+
+// PrivateKeySignerFromHex parse a hex encoded private key, with or without the 0x prefix
+func PrivateKeySignerFromHex(hexKey string) (*PrivateKeySigner, error) {
+	key, err := crypto.HexToECDSA(strings.TrimPrefix(hexKey, "0x"))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse private key")
+	}
+	return NewPrivateKeySigner(key), nil
+}
+
+func (s *PrivateKeySigner) Address() common.Address {
+	return s.address
+}
+
+func (s *PrivateKeySigner) SignTx(_ context.Context, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	return types.SignTx(tx, types.LatestSignerForChainID(chainID), s.key)
+}
@@ -0,0 +1,343 @@
+package client_pool
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/duongtuttbn/toolkit/log"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+)
+
+type (
+	StreamOptions struct {
+		// Confirmations is how many blocks behind the head a block must be before its logs are emitted
+		Confirmations uint64
+		// PollInterval is the time between two head checks once the stream caught up, default to 5 seconds
+		PollInterval time.Duration
+		// ReorgDepth is how many recent blocks are tracked to detect reorganisations, default to 64
+		ReorgDepth uint64
+		// MaxBlockRange is the largest block range emitted in one batch, default to 1000
+		MaxBlockRange uint64
+		// BufferSize is the capacity of the returned channel, default to 16
+		BufferSize int
+	}
+
+	// LogBatch is the logs of the block range [FromBlock, ToBlock]. When Reorg is set, Logs are the previously
+	// emitted logs of orphaned blocks, with Removed set. Err is set on the last batch if the stream failed
+	LogBatch struct {
+		FromBlock uint64
+		ToBlock   uint64
+		Logs      []types.Log
+		Reorg     bool
+		Err       error
+	}
+
+	logStream struct {
+		pool  *ClientPool
+		query ethereum.FilterQuery
+		opts  StreamOptions
+		out   chan LogBatch
+		// next is the first block whose logs are not emitted yet
+		next uint64
+		// hashes and emitted track the recent blocks to detect reorganisations and remove their logs
+		hashes  map[uint64]common.Hash
+		emitted map[uint64][]types.Log
+	}
+)
+
+const (
+	defaultStreamPollInterval  = 5 * time.Second
+	defaultStreamReorgDepth    = 64
+	defaultStreamMaxBlockRange = 1000
+	defaultStreamBufferSize    = 16
+)
+
+// StreamLogs follow the chain from startBlock and emit the logs matching filterQuery once they have
+// opts.Confirmations confirmations. On a reorganisation, the logs of orphaned blocks are emitted again
+// with Removed set before the logs of the new blocks. Without confirmations and with a websocket client
+// in the pool, new logs are received through a subscription, otherwise the head is polled.
+// The channel is closed when ctx is done or after a batch carrying an error
+func (pool *ClientPool) StreamLogs(
+	ctx context.Context,
+	filterQuery ethereum.FilterQuery,
+	startBlock uint64,
+	opts StreamOptions,
+) (<-chan LogBatch, error) {
+	if filterQuery.BlockHash != nil {
+		return nil, errors.New("unable to stream logs of a filter query with block hash")
+	}
+	stream := newLogStream(pool, filterQuery, startBlock, opts)
+	go stream.run(ctx)
+	return stream.out, nil
+}
+
+func newLogStream(pool *ClientPool, filterQuery ethereum.FilterQuery, startBlock uint64, opts StreamOptions) *logStream {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaultStreamPollInterval
+	}
+	if opts.ReorgDepth == 0 {
+		opts.ReorgDepth = defaultStreamReorgDepth
+	}
+	if opts.MaxBlockRange == 0 {
+		opts.MaxBlockRange = defaultStreamMaxBlockRange
+	}
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = defaultStreamBufferSize
+	}
+	return &logStream{
+		pool:    pool,
+		query:   filterQuery,
+		opts:    opts,
+		out:     make(chan LogBatch, opts.BufferSize),
+		next:    startBlock,
+		hashes:  make(map[uint64]common.Hash),
+		emitted: make(map[uint64][]types.Log),
+	}
+}
+
+func (s *logStream) run(ctx context.Context) {
+	defer close(s.out)
+	for {
+		caughtUp, err := s.poll(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				s.send(ctx, LogBatch{FromBlock: s.next, ToBlock: s.next, Err: err})
+			}
+			return
+		}
+		if !caughtUp {
+			continue
+		}
+		if client := s.pool.websocketClient(); client != nil && s.opts.Confirmations == 0 {
+			if err := s.follow(ctx, client); err != nil && ctx.Err() == nil {
+				log.FromContext(ctx).Errorf("Subscribe logs on endpoint %s error, fall back to polling: %v", client.Label(), err)
+			}
+		}
+		if err := SleepContext(ctx, s.opts.PollInterval); err != nil {
+			return
+		}
+	}
+}
+
+// poll check the head for a reorganisation, then emit the logs of the next confirmed block range.
+// It returns true once every confirmed block is emitted
+func (s *logStream) poll(ctx context.Context) (bool, error) {
+	head, err := s.pool.GetLatestBlockContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	if head < s.opts.Confirmations {
+		return true, nil
+	}
+	safe := head - s.opts.Confirmations
+	if err := s.checkReorg(ctx); err != nil {
+		return false, err
+	}
+	if s.next > safe {
+		return true, nil
+	}
+	to := safe
+	if to-s.next >= s.opts.MaxBlockRange {
+		to = s.next + s.opts.MaxBlockRange - 1
+	}
+	logs, err := s.pool.getLogs(ctx, s.query, s.next, to)
+	if err != nil {
+		return false, err
+	}
+	header, err := s.pool.GetBlockHeader(ctx, to)
+	if err != nil {
+		return false, err
+	}
+	s.hashes[to] = header.Hash()
+	// the first block may be partially emitted by the subscription before it failed
+	logs = slices.DeleteFunc(logs, s.seen)
+	for _, l := range logs {
+		s.track(l)
+	}
+	if !s.send(ctx, LogBatch{FromBlock: s.next, ToBlock: to, Logs: logs}) {
+		return false, ctx.Err()
+	}
+	s.next = to + 1
+	s.prune()
+	return to == safe, nil
+}
+
+// checkReorg compare the tracked block hashes with the canonical chain, from the most recent one,
+// and rewind the stream to the most recent block that is still canonical
+func (s *logStream) checkReorg(ctx context.Context) error {
+	if len(s.hashes) == 0 {
+		return nil
+	}
+	heights := make([]uint64, 0, len(s.hashes))
+	for height := range s.hashes {
+		heights = append(heights, height)
+	}
+	slices.Sort(heights)
+	slices.Reverse(heights)
+	for i, height := range heights {
+		header, err := s.pool.GetBlockHeader(ctx, height)
+		if err != nil {
+			return err
+		}
+		if header.Hash() != s.hashes[height] {
+			continue
+		}
+		if i > 0 {
+			return s.rewind(ctx, height)
+		}
+		return nil
+	}
+	return errors.Errorf("chain reorganisation deeper than %d blocks before block %d", s.opts.ReorgDepth, s.next)
+}
+
+// rewind emit the logs of the blocks after ancestor as removed and restart the stream after ancestor
+func (s *logStream) rewind(ctx context.Context, ancestor uint64) error {
+	log.FromContext(ctx).Infof("Chain reorganisation detected, rewind log stream from block %d to %d", s.next-1, ancestor)
+	// the block at next may be partially emitted by the subscription, it is orphaned as well
+	heights := make([]uint64, 0)
+	for height := range s.emitted {
+		if height > ancestor {
+			heights = append(heights, height)
+		}
+	}
+	slices.Sort(heights)
+	slices.Reverse(heights)
+	last := s.next - 1
+	var removed []types.Log
+	for _, height := range heights {
+		logs := s.emitted[height]
+		for i := len(logs) - 1; i >= 0; i-- {
+			l := logs[i]
+			l.Removed = true
+			removed = append(removed, l)
+		}
+		delete(s.emitted, height)
+		last = max(last, height)
+	}
+	for height := range s.hashes {
+		if height > ancestor {
+			delete(s.hashes, height)
+		}
+	}
+	batch := LogBatch{FromBlock: ancestor + 1, ToBlock: last, Logs: removed, Reorg: true}
+	s.next = ancestor + 1
+	if !s.send(ctx, batch) {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// follow emit the logs received through a websocket subscription until it fails or ctx is done.
+// The node itself send the logs of orphaned blocks again with Removed set
+func (s *logStream) follow(ctx context.Context, client *Client) error {
+	ch := make(chan types.Log, s.opts.BufferSize)
+	query := s.query
+	query.FromBlock, query.ToBlock = nil, nil
+	sub, err := client.SubscribeFilterLogs(ctx, query, ch)
+	if err != nil {
+		return client.redactError(err)
+	}
+	defer sub.Unsubscribe()
+	// emit the logs of the blocks mined before the subscription started
+	for {
+		caughtUp, err := s.poll(ctx)
+		if err != nil {
+			return err
+		}
+		if caughtUp {
+			break
+		}
+	}
+	liveFrom := s.next
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-sub.Err():
+			return client.redactError(err)
+		case l := <-ch:
+			if !s.receive(ctx, l, liveFrom) {
+				return nil
+			}
+		}
+	}
+}
+
+// receive emit a log of the subscription, it returns false when ctx is done. The logs of a block are received
+// in order, so a log of a later block means the previous blocks are complete, but its own block may not be:
+// next stay on it, and if polling resumes the block is fetched again without the logs already emitted
+func (s *logStream) receive(ctx context.Context, l types.Log, liveFrom uint64) bool {
+	if l.Removed {
+		s.untrack(l)
+		return s.send(ctx, LogBatch{FromBlock: l.BlockNumber, ToBlock: l.BlockNumber, Logs: []types.Log{l}, Reorg: true})
+	}
+	if l.BlockNumber < liveFrom || s.seen(l) {
+		return true
+	}
+	s.track(l)
+	if !s.send(ctx, LogBatch{FromBlock: l.BlockNumber, ToBlock: l.BlockNumber, Logs: []types.Log{l}}) {
+		return false
+	}
+	if l.BlockNumber > s.next {
+		s.next = l.BlockNumber
+	}
+	s.prune()
+	return true
+}
+
+func (s *logStream) track(l types.Log) {
+	s.hashes[l.BlockNumber] = l.BlockHash
+	s.emitted[l.BlockNumber] = append(s.emitted[l.BlockNumber], l)
+}
+
+// seen let you know that the log was already emitted and not removed since
+func (s *logStream) seen(l types.Log) bool {
+	return slices.ContainsFunc(s.emitted[l.BlockNumber], func(emitted types.Log) bool {
+		return emitted.BlockHash == l.BlockHash && emitted.TxHash == l.TxHash && emitted.Index == l.Index
+	})
+}
+
+func (s *logStream) untrack(l types.Log) {
+	logs := s.emitted[l.BlockNumber]
+	for i := range logs {
+		if logs[i].TxHash == l.TxHash && logs[i].Index == l.Index {
+			s.emitted[l.BlockNumber] = append(logs[:i], logs[i+1:]...)
+			break
+		}
+	}
+	if len(s.emitted[l.BlockNumber]) == 0 {
+		delete(s.emitted, l.BlockNumber)
+		delete(s.hashes, l.BlockNumber)
+	}
+}
+
+// prune forget the blocks older than the reorg depth
+func (s *logStream) prune() {
+	if s.next <= s.opts.ReorgDepth {
+		return
+	}
+	oldest := s.next - s.opts.ReorgDepth
+	for height := range s.hashes {
+		if height < oldest {
+			delete(s.hashes, height)
+		}
+	}
+	for height := range s.emitted {
+		if height < oldest {
+			delete(s.emitted, height)
+		}
+	}
+}
+
+func (s *logStream) send(ctx context.Context, batch LogBatch) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case s.out <- batch:
+		return true
+	}
+}
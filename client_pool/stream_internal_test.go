@@ -0,0 +1,59 @@
+package client_pool
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/duongtuttbn/toolkit/client_pool/rpctest"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestStreamResumesPartiallyReceivedBlock(t *testing.T) {
+	chain := rpctest.NewChain(100)
+	server := rpctest.NewServer(chain)
+	t.Cleanup(server.Close)
+	pool, err := NewBasicClientPool(Config{RpcUrls: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream := newLogStream(pool, ethereum.FilterQuery{}, 0, StreamOptions{})
+	if caughtUp, err := stream.poll(ctx); err != nil || !caughtUp {
+		t.Fatalf("first poll caught up %v with error %v", caughtUp, err)
+	}
+	<-stream.out
+
+	chain.AddLogs(
+		types.Log{Address: common.HexToAddress("0x1"), Topics: []common.Hash{}, BlockNumber: 101, TxHash: common.HexToHash("0x1"), Index: 0},
+		types.Log{Address: common.HexToAddress("0x1"), Topics: []common.Hash{}, BlockNumber: 101, TxHash: common.HexToHash("0x2"), Index: 1},
+	)
+	chain.Mine(1)
+	// the subscription deliver the first log of block 101, then drop before the second one
+	logs := chain.Logs(101, 101)
+	if !stream.receive(ctx, logs[0], 101) {
+		t.Fatal("log not received")
+	}
+	if received := <-stream.out; len(received.Logs) != 1 || received.Logs[0].TxHash != logs[0].TxHash {
+		t.Fatalf("unexpected live batch: %+v", received)
+	}
+	if stream.next != 101 {
+		t.Fatalf("next block is %d, want the partially received block 101", stream.next)
+	}
+
+	if _, err := stream.poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	resumed := <-stream.out
+	if resumed.FromBlock != 101 || len(resumed.Logs) != 1 || resumed.Logs[0].TxHash != logs[1].TxHash {
+		t.Fatalf("got batch [%d, %d] with logs %+v, want only the second log of block 101",
+			resumed.FromBlock, resumed.ToBlock, resumed.Logs)
+	}
+	if stream.next != 102 {
+		t.Fatalf("next block is %d, want 102", stream.next)
+	}
+}
@@ -0,0 +1,66 @@
+package client_pool_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/duongtuttbn/toolkit/client_pool"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestStreamLogsRewindsOnReorg(t *testing.T) {
+	f := newFixture(t, 100, 1)
+	addTransfers(f.chain, 10, 95, 99)
+	pool := f.pool(t, client_pool.Config{})
+	query := ethereum.FilterQuery{Topics: [][]common.Hash{{transferTopic}}}
+	batches, err := pool.StreamLogs(f.ctx, query, 0, client_pool.StreamOptions{PollInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := func() client_pool.LogBatch {
+		t.Helper()
+		select {
+		case batch, ok := <-batches:
+			if !ok {
+				t.Fatal("stream closed")
+			}
+			if batch.Err != nil {
+				t.Fatal(batch.Err)
+			}
+			return batch
+		case <-f.ctx.Done():
+			t.Fatal("no batch before the test deadline")
+		}
+		return client_pool.LogBatch{}
+	}
+
+	batch := next()
+	if batch.FromBlock != 0 || batch.ToBlock != 100 || len(batch.Logs) != 3 || batch.Reorg {
+		t.Fatalf("got batch [%d, %d] with %d logs, want [0, 100] with 3 logs", batch.FromBlock, batch.ToBlock, len(batch.Logs))
+	}
+
+	// blocks 98 to 100 are replaced, the transfer of block 99 is orphaned and a new one is mined in block 98
+	f.chain.Reorg(98, types.Log{
+		Address:     common.HexToAddress("0x1"),
+		Topics:      []common.Hash{transferTopic},
+		BlockNumber: 98,
+		TxHash:      common.HexToHash("0x98"),
+	})
+	removed := next()
+	// block 95 is the most recent tracked block that is still canonical
+	if !removed.Reorg || removed.FromBlock != 96 || removed.ToBlock != 100 {
+		t.Fatalf("got batch [%d, %d] reorg %v, want the reorg of [96, 100]", removed.FromBlock, removed.ToBlock, removed.Reorg)
+	}
+	if len(removed.Logs) != 1 || !removed.Logs[0].Removed || removed.Logs[0].BlockNumber != 99 {
+		t.Fatalf("got removed logs %+v, want the transfer of block 99", removed.Logs)
+	}
+	replayed := next()
+	if replayed.Reorg || replayed.FromBlock != 96 || replayed.ToBlock != 100 {
+		t.Fatalf("got batch [%d, %d] reorg %v, want [96, 100] fetched again", replayed.FromBlock, replayed.ToBlock, replayed.Reorg)
+	}
+	if len(replayed.Logs) != 1 || replayed.Logs[0].TxHash != common.HexToHash("0x98") || replayed.Logs[0].BlockHash != f.chain.Header(98).Hash() {
+		t.Fatalf("got logs %+v, want the transfer of the new block 98", replayed.Logs)
+	}
+}
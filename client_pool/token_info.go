@@ -0,0 +1,275 @@
+package client_pool
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"slices"
+	"unicode/utf8"
+
+	"github.com/duongtuttbn/toolkit/log"
+	"github.com/duongtuttbn/toolkit/model"
+	"github.com/duongtuttbn/toolkit/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/pkg/errors"
+)
+
+var (
+	tokenFields = []string{"name", "symbol", "decimals", "totalSupply"}
+
+	// eip1967ImplementationSlot is keccak256("eip1967.proxy.implementation") - 1
+	eip1967ImplementationSlot = common.HexToHash("0x360894a13ba1a3210667c828492db98dca3e2076cc3735a920a3ca505d382bbc")
+	// eip1967BeaconSlot is keccak256("eip1967.proxy.beacon") - 1
+	eip1967BeaconSlot = common.HexToHash("0xa3f0ad74e5423aebfd80d3ef4346578335a9a72aeea5deac7c7e4c43d87d0d50")
+	// zeppelinosImplementationSlot is keccak256("org.zeppelinos.proxy.implementation"), used before EIP-1967
+	zeppelinosImplementationSlot = common.HexToHash("0x7050c9e0f4ca769c69bd3a8ef740bc37934f8e2c036a5a723fd8ee048ed3f8c3")
+	// implementationSelector is the selector of implementation(), exposed by beacons
+	implementationSelector = common.FromHex("0x5c60da1b")
+
+	// ErrNotToken is returned for an address where none of the ERC-20 metadata could be read
+	ErrNotToken = errors.New("no ERC-20 metadata could be read")
+)
+
+// GetTokenInfos return the ERC-20 metadata of the given tokens, fetched through Multicall3.
+// Non-standard tokens are decoded leniently: bytes32 name and symbol are accepted, a missing method leaves
+// its field in TokenInfo.Unresolved, and the fields a proxy could not answer are read from its implementation.
+// Invalid addresses and tokens where nothing could be read are reported in a BatchError
+func (pool *ClientPool) GetTokenInfos(ctx context.Context, tokenAddresses []string) (_ map[string]*model.TokenInfo, err error) {
+	ctx, end := pool.startSpan(ctx, "GetTokenInfos", attrBatchSize.Int(len(tokenAddresses)))
+	defer func() { end(err) }()
+	batchErr := BatchError[string]{}
+	tokens := make(map[string]*model.TokenInfo, len(tokenAddresses))
+	targets := make(map[string]common.Address, len(tokenAddresses))
+	for _, tokenAddress := range tokenAddresses {
+		target, err := parseAddress(tokenAddress)
+		if err != nil {
+			batchErr[tokenAddress] = err
+			continue
+		}
+		tokens[tokenAddress] = &model.TokenInfo{TokenAddress: tokenAddress}
+		targets[tokenAddress] = target
+	}
+	if err := pool.resolveTokenFields(ctx, tokens, targets, tokenFields); err != nil {
+		return nil, err
+	}
+
+	proxies := make(map[string]common.Address)
+	for tokenAddress, token := range tokens {
+		if len(token.Unresolved) > 0 {
+			proxies[tokenAddress] = targets[tokenAddress]
+		}
+	}
+	implementations, err := pool.proxyImplementations(ctx, proxies)
+	if err != nil {
+		return nil, err
+	}
+	if len(implementations) > 0 {
+		before := make(map[string]int, len(implementations))
+		for tokenAddress := range implementations {
+			before[tokenAddress] = len(tokens[tokenAddress].Unresolved)
+		}
+		if err := pool.resolveTokenFields(ctx, tokens, implementations, nil); err != nil {
+			return nil, err
+		}
+		for tokenAddress, implementation := range implementations {
+			if len(tokens[tokenAddress].Unresolved) < before[tokenAddress] {
+				tokens[tokenAddress].Implementation = implementation.String()
+			}
+		}
+	}
+
+	for tokenAddress, token := range tokens {
+		if len(token.Unresolved) == len(tokenFields) {
+			batchErr[tokenAddress] = ErrNotToken
+			delete(tokens, tokenAddress)
+			continue
+		}
+		if token.TotalSupplyRaw != nil && !slices.Contains(token.Unresolved, "decimals") {
+			token.TotalSupply = utils.BigIntToFloat(token.TotalSupplyRaw, token.ContractDecimals)
+		}
+	}
+	if len(batchErr) > 0 {
+		return tokens, batchErr
+	}
+	return tokens, nil
+}
+
+// resolveTokenFields call the metadata methods on the target of every token and decode the answers into it.
+// With fields nil, only the fields left in TokenInfo.Unresolved are called on the proxy implementations,
+// otherwise Unresolved is rebuilt
+func (pool *ClientPool) resolveTokenFields(
+	ctx context.Context,
+	tokens map[string]*model.TokenInfo,
+	targets map[string]common.Address,
+	fields []string,
+) error {
+	type pendingField struct {
+		token *model.TokenInfo
+		field string
+	}
+	var calls []multicallCall
+	var pending []pendingField
+	for tokenAddress, target := range targets {
+		token := tokens[tokenAddress]
+		tokenFields := fields
+		if tokenFields == nil {
+			tokenFields = token.Unresolved
+		}
+		token.Unresolved = nil
+		for _, field := range tokenFields {
+			data, err := erc20ABI.Pack(field)
+			if err != nil {
+				return errors.Wrapf(err, "unable to pack %s call", field)
+			}
+			calls = append(calls, multicallCall{Target: target, CallData: data})
+			pending = append(pending, pendingField{token: token, field: field})
+		}
+	}
+	if len(calls) == 0 {
+		return nil
+	}
+	results, err := pool.multicall(ctx, calls)
+	if err != nil {
+		return err
+	}
+	for i, result := range results {
+		token, field := pending[i].token, pending[i].field
+		// the implementation answer from its own storage, never initialised behind a proxy,
+		// so its empty and zero values are not the ones of the token
+		if !result.Success || !decodeTokenField(token, field, result.ReturnData, fields == nil) {
+			token.Unresolved = append(token.Unresolved, field)
+		}
+	}
+	return nil
+}
+
+// decodeTokenField set the field of token from the return data of its method, reporting whether it could be decoded.
+// With nonZero, empty and zero values are rejected and leave the field untouched
+func decodeTokenField(token *model.TokenInfo, field string, data []byte, nonZero bool) bool {
+	switch field {
+	case "name", "symbol":
+		value, ok := decodeTokenString(data)
+		if !ok || nonZero && value == "" {
+			return false
+		}
+		if field == "name" {
+			token.TokenName = value
+		} else {
+			token.TokenSymbol = value
+		}
+		return true
+	case "decimals":
+		decimals, ok := decodeTokenUint(data)
+		if !ok || !decimals.IsUint64() || decimals.Uint64() > 255 || nonZero && decimals.Sign() == 0 {
+			return false
+		}
+		token.ContractDecimals = decimals.Int64()
+		return true
+	case "totalSupply":
+		totalSupply, ok := decodeTokenUint(data)
+		if !ok || nonZero && totalSupply.Sign() == 0 {
+			return false
+		}
+		token.TotalSupplyRaw = totalSupply
+		return true
+	}
+	return false
+}
+
+// decodeTokenString decode a string returned as an ABI string, or as a bytes32 padded with zeros like MKR does
+func decodeTokenString(data []byte) (string, bool) {
+	if unpacked, err := erc20ABI.Unpack("name", data); err == nil {
+		if value, ok := unpacked[0].(string); ok && utf8.ValidString(value) {
+			return value, true
+		}
+	}
+	if len(data) == common.HashLength {
+		value := string(bytes.TrimRight(data, "\x00"))
+		if value != "" && utf8.ValidString(value) {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// decodeTokenUint decode the first word of the return data, so uint8 and uint256 answers are both accepted
+func decodeTokenUint(data []byte) (*big.Int, bool) {
+	if len(data) < common.HashLength {
+		return nil, false
+	}
+	return new(big.Int).SetBytes(data[:common.HashLength]), true
+}
+
+// proxyImplementations return the implementations behind the EIP-1967, beacon or ZeppelinOS proxies among
+// the contracts, keyed like them. The slots of every contract are read in one batch, then the beacons are
+// called through Multicall3. Contracts that are not such proxies, or whose slots could not be read, are left out
+func (pool *ClientPool) proxyImplementations(
+	ctx context.Context,
+	contracts map[string]common.Address,
+) (map[string]common.Address, error) {
+	implementations := make(map[string]common.Address)
+	if len(contracts) == 0 {
+		return implementations, nil
+	}
+	// the slots are read in this order, the first one set tell the implementation or the beacon
+	slots := []common.Hash{eip1967ImplementationSlot, zeppelinosImplementationSlot, eip1967BeaconSlot}
+	keys := make([]string, 0, len(contracts))
+	for key := range contracts {
+		keys = append(keys, key)
+	}
+	values := make([]hexutil.Bytes, len(keys)*len(slots))
+	elems := make([]rpc.BatchElem, len(values))
+	for i, key := range keys {
+		for j, slot := range slots {
+			elems[i*len(slots)+j] = rpc.BatchElem{
+				Method: "eth_getStorageAt",
+				Args:   []interface{}{contracts[key], slot, "latest"},
+				Result: &values[i*len(slots)+j],
+			}
+		}
+	}
+	if err := pool.BatchCall(ctx, elems); err != nil {
+		return nil, err
+	}
+
+	var beaconKeys []string
+	var beaconCalls []multicallCall
+	for i, key := range keys {
+		for j, slot := range slots {
+			index := i*len(slots) + j
+			if elems[index].Error != nil {
+				log.FromContext(ctx).Errorf("get proxy implementation of %s error: %v", key, elems[index].Error)
+				break
+			}
+			address := common.BytesToAddress(values[index])
+			if address == (common.Address{}) {
+				continue
+			}
+			if slot == eip1967BeaconSlot {
+				beaconKeys = append(beaconKeys, key)
+				beaconCalls = append(beaconCalls, multicallCall{Target: address, CallData: implementationSelector})
+			} else {
+				implementations[key] = address
+			}
+			break
+		}
+	}
+	if len(beaconCalls) == 0 {
+		return implementations, nil
+	}
+	results, err := pool.multicall(ctx, beaconCalls)
+	if err != nil {
+		return nil, err
+	}
+	for i, result := range results {
+		if !result.Success || len(result.ReturnData) < common.HashLength {
+			continue
+		}
+		if implementation := common.BytesToAddress(result.ReturnData[:common.HashLength]); implementation != (common.Address{}) {
+			implementations[beaconKeys[i]] = implementation
+		}
+	}
+	return implementations, nil
+}
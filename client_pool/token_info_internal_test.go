@@ -0,0 +1,92 @@
+package client_pool
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/duongtuttbn/toolkit/model"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func abiString(t *testing.T, value string) []byte {
+	t.Helper()
+	typ, err := abi.NewType("string", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := abi.Arguments{{Type: typ}}.Pack(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDecodeTokenString(t *testing.T) {
+	for name, test := range map[string]struct {
+		data []byte
+		want string
+		ok   bool
+	}{
+		"abi string":         {data: abiString(t, "Wrapped Ether"), want: "Wrapped Ether", ok: true},
+		"empty abi string":   {data: abiString(t, ""), want: "", ok: true},
+		"bytes32 like MKR":   {data: common.RightPadBytes([]byte("Maker"), 32), want: "Maker", ok: true},
+		"zero word":          {data: make([]byte, 32), want: "", ok: true}, // read as an empty abi string
+		"invalid utf8":       {data: common.RightPadBytes([]byte{0xff, 0xfe}, 32)},
+		"short answer":       {data: []byte("MKR")},
+		"empty answer":       {data: nil},
+		"truncated abi data": {data: abiString(t, "Wrapped Ether")[:64]},
+	} {
+		got, ok := decodeTokenString(test.data)
+		if got != test.want || ok != test.ok {
+			t.Errorf("%s: got %q (ok %v), want %q (ok %v)", name, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestDecodeTokenField(t *testing.T) {
+	word := func(value int64) []byte {
+		return common.LeftPadBytes(big.NewInt(value).Bytes(), 32)
+	}
+	token := &model.TokenInfo{}
+	for _, field := range []struct {
+		name string
+		data []byte
+	}{
+		{"name", common.RightPadBytes([]byte("Maker"), 32)},
+		{"symbol", abiString(t, "MKR")},
+		{"decimals", word(18)},
+		{"totalSupply", word(1_000_000)},
+	} {
+		if !decodeTokenField(token, field.name, field.data, false) {
+			t.Fatalf("could not decode %s", field.name)
+		}
+	}
+	if token.TokenName != "Maker" || token.TokenSymbol != "MKR" || token.ContractDecimals != 18 || token.TotalSupplyRaw.Int64() != 1_000_000 {
+		t.Fatalf("unexpected token: %+v", token)
+	}
+
+	// decimals above 255 and answers shorter than a word are rejected, zero is a valid number of decimals
+	if decodeTokenField(token, "decimals", word(256), false) || decodeTokenField(token, "totalSupply", []byte{1}, false) {
+		t.Fatal("decoded an invalid answer")
+	}
+	if !decodeTokenField(token, "decimals", word(0), false) || token.ContractDecimals != 0 {
+		t.Fatal("could not decode zero decimals")
+	}
+
+	// from a proxy implementation, the empty and zero values of its uninitialised storage are rejected
+	implementation := &model.TokenInfo{}
+	for field, data := range map[string][]byte{
+		"name":        abiString(t, ""),
+		"symbol":      make([]byte, 32),
+		"decimals":    word(0),
+		"totalSupply": word(0),
+	} {
+		if decodeTokenField(implementation, field, data, true) {
+			t.Errorf("accepted the zero %s of an implementation", field)
+		}
+	}
+	if !decodeTokenField(implementation, "decimals", word(6), true) || implementation.ContractDecimals != 6 {
+		t.Fatal("could not decode the decimals of an implementation")
+	}
+}
@@ -0,0 +1,133 @@
+package client_pool_test
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"slices"
+	"testing"
+
+	"github.com/duongtuttbn/toolkit/client_pool"
+	"github.com/duongtuttbn/toolkit/client_pool/rpctest"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var (
+	eip1967ImplementationSlot = common.HexToHash("0x360894a13ba1a3210667c828492db98dca3e2076cc3735a920a3ca505d382bbc")
+	eip1967BeaconSlot         = common.HexToHash("0xa3f0ad74e5423aebfd80d3ef4346578335a9a72aeea5deac7c7e4c43d87d0d50")
+)
+
+// setStringView script a parameterless view method of the contract to return an ABI string
+func setStringView(t *testing.T, chain *rpctest.Chain, contract common.Address, signature, value string) {
+	t.Helper()
+	typ, err := abi.NewType("string", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := abi.Arguments{{Type: typ}}.Pack(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain.SetCall(contract, crypto.Keccak256([]byte(signature))[:4], output)
+}
+
+// setStorage answer eth_getStorageAt with the scripted slots, the others are zero
+func setStorage(server *rpctest.Server, slots map[common.Address]map[common.Hash]common.Address) {
+	server.Handle("eth_getStorageAt", func(params []json.RawMessage) (interface{}, error) {
+		var account common.Address
+		var slot common.Hash
+		if len(params) < 2 || json.Unmarshal(params[0], &account) != nil || json.Unmarshal(params[1], &slot) != nil {
+			return nil, &rpctest.Error{Code: -32602, Message: "invalid storage request"}
+		}
+		return common.BytesToHash(slots[account][slot].Bytes()), nil
+	})
+}
+
+func TestGetTokenInfos(t *testing.T) {
+	f := newFixture(t, 100, 1)
+	weth, mkr, noDecimals, notToken := common.HexToAddress("0xe0"), common.HexToAddress("0xe1"), common.HexToAddress("0xe2"), common.HexToAddress("0xe3")
+	setStringView(t, f.chain, weth, "name()", "Wrapped Ether")
+	setStringView(t, f.chain, weth, "symbol()", "WETH")
+	setView(f.chain, weth, "decimals()", big.NewInt(18).Bytes())
+	setView(f.chain, weth, "totalSupply()", big.NewInt(3e18).Bytes())
+	// MKR return its name and symbol as bytes32
+	f.chain.SetCall(mkr, crypto.Keccak256([]byte("name()"))[:4], common.RightPadBytes([]byte("Maker"), 32))
+	f.chain.SetCall(mkr, crypto.Keccak256([]byte("symbol()"))[:4], common.RightPadBytes([]byte("MKR"), 32))
+	setView(f.chain, mkr, "decimals()", big.NewInt(18).Bytes())
+	setView(f.chain, mkr, "totalSupply()", big.NewInt(1e18).Bytes())
+	setStringView(t, f.chain, noDecimals, "name()", "No Decimals")
+	setStringView(t, f.chain, noDecimals, "symbol()", "NODEC")
+	f.chain.SetRevert(notToken, "not a token")
+	pool := f.pool(t, client_pool.Config{})
+
+	tokens, err := pool.GetTokenInfos(f.ctx, []string{weth.Hex(), mkr.Hex(), noDecimals.Hex(), notToken.Hex(), "0xe4"})
+	var batchErr client_pool.BatchError[string]
+	if !errors.As(err, &batchErr) || len(batchErr) != 2 {
+		t.Fatalf("got error %v, want the reverting contract and the invalid address", err)
+	}
+	if !errors.Is(batchErr[notToken.Hex()], client_pool.ErrNotToken) || !errors.Is(batchErr["0xe4"], client_pool.ErrInvalidAddress) {
+		t.Fatalf("got errors %v", batchErr)
+	}
+	if token := tokens[weth.Hex()]; token == nil || token.TokenName != "Wrapped Ether" || token.TokenSymbol != "WETH" ||
+		token.ContractDecimals != 18 || token.TotalSupply != 3 || len(token.Unresolved) != 0 {
+		t.Fatalf("unexpected WETH: %+v", token)
+	}
+	if token := tokens[mkr.Hex()]; token == nil || token.TokenName != "Maker" || token.TokenSymbol != "MKR" || token.TotalSupply != 1 {
+		t.Fatalf("unexpected MKR: %+v", token)
+	}
+	// the missing methods are left unresolved, without scaling the total supply by unknown decimals
+	if token := tokens[noDecimals.Hex()]; token == nil || token.TokenName != "No Decimals" ||
+		!slices.Equal(token.Unresolved, []string{"decimals", "totalSupply"}) || token.TotalSupply != 0 {
+		t.Fatalf("unexpected token without decimals: %+v", token)
+	}
+	// the contracts with unresolved fields were checked for a proxy implementation, in one batch
+	if requests := f.servers[0].Requests("eth_getStorageAt"); requests != 6 {
+		t.Fatalf("server got %d eth_getStorageAt, want the 3 slots of the 2 incomplete contracts", requests)
+	}
+	// the single token form return the error of the token rather than the whole BatchError
+	if _, err := pool.GetTokenInfoContext(f.ctx, notToken.Hex()); !errors.Is(err, client_pool.ErrNotToken) {
+		t.Fatalf("got error %v, want ErrNotToken", err)
+	}
+	if token, err := pool.GetTokenInfoContext(f.ctx, mkr.Hex()); err != nil || token.TokenSymbol != "MKR" {
+		t.Fatalf("got token %+v (error %v), want MKR", token, err)
+	}
+}
+
+func TestGetTokenInfosOfProxies(t *testing.T) {
+	f := newFixture(t, 100, 1)
+	proxy, uninitialised := common.HexToAddress("0xf0"), common.HexToAddress("0xf1")
+	implementation, beacon := common.HexToAddress("0xf2"), common.HexToAddress("0xf3")
+	for _, contract := range []common.Address{proxy, uninitialised} {
+		setStringView(t, f.chain, contract, "name()", "USD Coin")
+		setStringView(t, f.chain, contract, "symbol()", "USDC")
+	}
+	// the implementation of the beacon proxy answer from its own storage, only the decimals are set there
+	setView(f.chain, implementation, "decimals()", big.NewInt(6).Bytes())
+	setView(f.chain, implementation, "totalSupply()", nil)
+	setView(f.chain, beacon, "implementation()", implementation.Bytes())
+	setStorage(f.servers[0], map[common.Address]map[common.Hash]common.Address{
+		proxy:         {eip1967BeaconSlot: beacon},
+		uninitialised: {eip1967ImplementationSlot: common.HexToAddress("0xf4")},
+	})
+	setView(f.chain, common.HexToAddress("0xf4"), "decimals()", nil)
+	setView(f.chain, common.HexToAddress("0xf4"), "totalSupply()", nil)
+	pool := f.pool(t, client_pool.Config{})
+
+	tokens, err := pool.GetTokenInfos(f.ctx, []string{proxy.Hex(), uninitialised.Hex()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := tokens[proxy.Hex()]
+	if token == nil || token.ContractDecimals != 6 || token.Implementation != implementation.Hex() ||
+		!slices.Equal(token.Unresolved, []string{"totalSupply"}) {
+		t.Fatalf("unexpected beacon proxy: %+v", token)
+	}
+	// zero decimals and total supply read from an implementation are its uninitialised storage, not the token ones
+	token = tokens[uninitialised.Hex()]
+	if token == nil || token.TokenSymbol != "USDC" || token.Implementation != "" ||
+		!slices.Equal(token.Unresolved, []string{"decimals", "totalSupply"}) {
+		t.Fatalf("unexpected proxy of an uninitialised implementation: %+v", token)
+	}
+}
@@ -0,0 +1,126 @@
+package client_pool
+
+import (
+	"context"
+
+	"github.com/duongtuttbn/toolkit/log"
+	"github.com/ethereum/go-ethereum/rpc"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const tracerName = "github.com/duongtuttbn/toolkit/client_pool"
+
+// span attributes set on the pool calls and their attempts
+const (
+	attrEndpoint   = attribute.Key("rpc.endpoint")
+	attrMethod     = attribute.Key("rpc.method")
+	attrFromBlock  = attribute.Key("block.from")
+	attrToBlock    = attribute.Key("block.to")
+	attrErrorClass = attribute.Key("rpc.error_class")
+	attrBatchSize  = attribute.Key("rpc.batch_size")
+	attrTxHash     = attribute.Key("tx.hash")
+	attrTimestamp  = attribute.Key("block.timestamp")
+)
+
+type (
+	// attempt describe the request sent by call, so its span can be labelled
+	attempt struct {
+		method    string
+		hasBlocks bool
+		fromBlock uint64
+		toBlock   uint64
+	}
+
+	attemptKey struct{}
+)
+
+// newTracer return the tracer of the provider, tracing is disabled when it is nil
+func newTracer(provider trace.TracerProvider) trace.Tracer {
+	if provider == nil {
+		provider = noop.NewTracerProvider()
+	}
+	return provider.Tracer(tracerName)
+}
+
+// startSpan start the span of a logical pool call. The returned ctx carries the span and a logger
+// of the log package with its trace and span ids, end must be called with the error of the call
+func (pool *ClientPool) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, func(error)) {
+	ctx, span := pool.tracer.Start(ctx, "ClientPool."+name, trace.WithAttributes(attrs...))
+	if spanContext := span.SpanContext(); spanContext.IsValid() {
+		ctx = log.NewContext(ctx, log.FromContext(ctx).WithFields(log.Fields{
+			"trace_id": spanContext.TraceID().String(),
+			"span_id":  spanContext.SpanID().String(),
+		}))
+	}
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			span.SetAttributes(attrErrorClass.String(pool.Classify(err).String()))
+		}
+		span.End()
+	}
+}
+
+// blockAttrs return the span attributes of [fromBlock, toBlock]
+func blockAttrs(fromBlock, toBlock uint64) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attrFromBlock.Int64(int64(fromBlock)),
+		attrToBlock.Int64(int64(toBlock)),
+	}
+}
+
+// withMethod label the requests sent by call with ctx with the RPC method
+func withMethod(ctx context.Context, method string) context.Context {
+	return context.WithValue(ctx, attemptKey{}, attempt{method: method})
+}
+
+// withBlockRange label the requests sent by call with ctx with the RPC method and the blocks they cover
+func withBlockRange(ctx context.Context, method string, fromBlock, toBlock uint64) context.Context {
+	return context.WithValue(ctx, attemptKey{}, attempt{method: method, hasBlocks: true, fromBlock: fromBlock, toBlock: toBlock})
+}
+
+// batchMethod return the method of the batch elements if they share one, "batch" otherwise
+func batchMethod(elems []rpc.BatchElem) string {
+	if len(elems) == 0 {
+		return "batch"
+	}
+	for _, elem := range elems[1:] {
+		if elem.Method != elems[0].Method {
+			return "batch"
+		}
+	}
+	return elems[0].Method
+}
+
+// startAttempt start the span of a single request sent to the client
+func (c *Client) startAttempt(ctx context.Context) (context.Context, trace.Span) {
+	a, _ := ctx.Value(attemptKey{}).(attempt)
+	name := "rpc"
+	attrs := []attribute.KeyValue{attrEndpoint.String(c.Label())}
+	if a.method != "" {
+		name = a.method
+		attrs = append(attrs, attrMethod.String(a.method))
+	}
+	if a.hasBlocks {
+		attrs = append(attrs, blockAttrs(a.fromBlock, a.toBlock)...)
+	}
+	tracer := c.tracer
+	if tracer == nil {
+		tracer = newTracer(nil)
+	}
+	return tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// endAttempt end the span of a request, class is ignored when err is nil
+func endAttempt(span trace.Span, err error, class ErrorClass) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.SetAttributes(attrErrorClass.String(class.String()))
+	}
+	span.End()
+}
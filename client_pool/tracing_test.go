@@ -0,0 +1,63 @@
+package client_pool_test
+
+import (
+	"testing"
+
+	"github.com/duongtuttbn/toolkit/client_pool"
+	"github.com/duongtuttbn/toolkit/client_pool/rpctest"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracingSpans(t *testing.T) {
+	chain := rpctest.NewChain(1000)
+	addTransfers(chain, 10, 500)
+	server := newServer(t, chain)
+	server.SetMaxLogRange(500)
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	pool := newPool(t, client_pool.Config{TracerProvider: provider}, server)
+
+	ctx := testContext(t)
+	if _, err := pool.GetLogsContext(ctx, ethereum.FilterQuery{Topics: [][]common.Hash{{transferTopic}}}, 0, 1000, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exporter.GetSpans()
+	var parent tracetest.SpanStub
+	for _, span := range spans {
+		if span.Name == "ClientPool.GetLogs" {
+			parent = span
+		}
+	}
+	if !parent.SpanContext.IsValid() {
+		t.Fatalf("no GetLogs span in %d spans", len(spans))
+	}
+	attempts, tooLarge := 0, 0
+	for _, span := range spans {
+		if span.Name != "eth_getLogs" {
+			continue
+		}
+		attempts++
+		if span.Parent.SpanID() != parent.SpanContext.SpanID() {
+			t.Fatalf("attempt span %s is not a child of the GetLogs span", span.SpanContext.SpanID())
+		}
+		attrs := attribute.NewSet(span.Attributes...)
+		if endpoint, _ := attrs.Value("rpc.endpoint"); endpoint.AsString() != server.URL {
+			t.Fatalf("attempt span endpoint is %q, want %q", endpoint.AsString(), server.URL)
+		}
+		if !attrs.HasValue("block.from") || !attrs.HasValue("block.to") {
+			t.Fatalf("attempt span has no block range: %v", span.Attributes)
+		}
+		if class, ok := attrs.Value("rpc.error_class"); ok && class.AsString() == "range_too_large" {
+			tooLarge++
+		}
+	}
+	// the default window is larger than 500 blocks, so the first attempt is rejected before the range is split
+	if attempts < 3 || tooLarge == 0 {
+		t.Fatalf("got %d eth_getLogs attempts with %d rejected as too large, want the range split", attempts, tooLarge)
+	}
+}
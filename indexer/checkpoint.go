@@ -0,0 +1,115 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	// CheckpointStore persist the last block fully processed by an indexer
+	CheckpointStore interface {
+		// Load return the last processed block of the indexer named key, ok is false if there is none
+		Load(ctx context.Context, key string) (block uint64, ok bool, err error)
+		// Save record block as the last processed block of the indexer named key
+		Save(ctx context.Context, key string, block uint64) error
+	}
+
+	// MemoryCheckpointStore keep the checkpoints in memory, they are lost on restart
+	MemoryCheckpointStore struct {
+		mu          sync.Mutex
+		checkpoints map[string]uint64
+	}
+
+	// FileCheckpointStore keep the checkpoints in a JSON file, replaced atomically on every save
+	FileCheckpointStore struct {
+		mu   sync.Mutex
+		path string
+	}
+)
+
+func NewMemoryCheckpointStore() *MemoryCheckpointStore {
+	return &MemoryCheckpointStore{checkpoints: make(map[string]uint64)}
+}
+
+func (s *MemoryCheckpointStore) Load(_ context.Context, key string) (uint64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	block, ok := s.checkpoints[key]
+	return block, ok, nil
+}
+
+func (s *MemoryCheckpointStore) Save(_ context.Context, key string, block uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkpoints[key] = block
+	return nil
+}
+
+func NewFileCheckpointStore(path string) *FileCheckpointStore {
+	return &FileCheckpointStore{path: path}
+}
+
+func (s *FileCheckpointStore) Load(_ context.Context, key string) (uint64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	checkpoints, err := s.read()
+	if err != nil {
+		return 0, false, err
+	}
+	block, ok := checkpoints[key]
+	return block, ok, nil
+}
+
+func (s *FileCheckpointStore) Save(_ context.Context, key string, block uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	checkpoints, err := s.read()
+	if err != nil {
+		return err
+	}
+	checkpoints[key] = block
+	data, err := json.MarshalIndent(checkpoints, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "unable to encode checkpoints")
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "unable to create checkpoint file")
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "unable to write checkpoint file")
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "unable to sync checkpoint file")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "unable to close checkpoint file")
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return errors.Wrap(err, "unable to replace checkpoint file")
+	}
+	return nil
+}
+
+func (s *FileCheckpointStore) read() (map[string]uint64, error) {
+	checkpoints := make(map[string]uint64)
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return checkpoints, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read checkpoint file")
+	}
+	if err := json.Unmarshal(data, &checkpoints); err != nil {
+		return nil, errors.Wrap(err, "unable to decode checkpoint file")
+	}
+	return checkpoints, nil
+}
@@ -0,0 +1,170 @@
+package indexer
+
+import (
+	"context"
+	"time"
+
+	"github.com/duongtuttbn/toolkit/client_pool"
+	"github.com/duongtuttbn/toolkit/log"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+)
+
+type (
+	// Handler process the logs of the block range [fromBlock, toBlock]. The range is only checkpointed
+	// once the handler succeeded, so it must tolerate being called again with the same range after a restart
+	Handler func(ctx context.Context, fromBlock, toBlock uint64, logs []types.Log) error
+
+	Config struct {
+		// Name identify the indexer in the checkpoint store
+		Name        string
+		FilterQuery ethereum.FilterQuery
+		// StartBlock is the first block indexed when there is no checkpoint yet
+		StartBlock uint64
+		// BatchSize is the largest block range given to the handler at once, default to 1000
+		BatchSize uint64
+		// Confirmations is how many blocks behind the head a block must be before it is indexed
+		Confirmations uint64
+		// NumProof is the number of endpoints that must agree on the logs, see ClientPool.GetLogs
+		NumProof int
+		// PollInterval is the time between two head checks once the indexer caught up, default to 10 seconds
+		PollInterval time.Duration
+		// MaxRetries is how many times the handler is retried on the same range before Run fails, default to 5
+		MaxRetries int
+		// RetryDelay is the delay before the first handler retry, doubled on each retry, default to 1 second
+		RetryDelay time.Duration
+	}
+
+	// Indexer feed the logs of a pool to a handler range after range, resuming from the last checkpoint
+	Indexer struct {
+		pool    *client_pool.ClientPool
+		store   CheckpointStore
+		handler Handler
+		config  Config
+	}
+)
+
+const (
+	defaultBatchSize    = 1000
+	defaultPollInterval = 10 * time.Second
+	defaultMaxRetries   = 5
+	defaultRetryDelay   = time.Second
+)
+
+func NewIndexer(pool *client_pool.ClientPool, store CheckpointStore, handler Handler, cfg Config) *Indexer {
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultPollInterval
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = defaultMaxRetries
+	}
+	if cfg.RetryDelay <= 0 {
+		cfg.RetryDelay = defaultRetryDelay
+	}
+	return &Indexer{
+		pool:    pool,
+		store:   store,
+		handler: handler,
+		config:  cfg,
+	}
+}
+
+// Run index the chain until ctx is done or the handler keeps failing on a range.
+// It returns nil when ctx is done
+func (idx *Indexer) Run(ctx context.Context) error {
+	fromBlock, err := idx.resumeBlock(ctx)
+	if err != nil {
+		return err
+	}
+	log.FromContext(ctx).Infof("Indexer %s start from block %d", idx.config.Name, fromBlock)
+	for {
+		toBlock, ok, err := idx.nextRange(ctx, fromBlock)
+		if err != nil {
+			return ignoreDone(ctx, err)
+		}
+		if !ok {
+			if err := client_pool.SleepContext(ctx, idx.config.PollInterval); err != nil {
+				return nil
+			}
+			continue
+		}
+		logs, err := idx.pool.GetLogsContext(ctx, idx.config.FilterQuery, fromBlock, toBlock, idx.config.NumProof)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.FromContext(ctx).Errorf("Indexer %s fetch logs [%d to %d] error: %v", idx.config.Name, fromBlock, toBlock, err)
+			if err := client_pool.SleepContext(ctx, idx.config.RetryDelay); err != nil {
+				return nil
+			}
+			continue
+		}
+		if err := idx.handle(ctx, fromBlock, toBlock, logs); err != nil {
+			return ignoreDone(ctx, err)
+		}
+		if err := idx.store.Save(ctx, idx.config.Name, toBlock); err != nil {
+			return errors.Wrapf(err, "unable to save checkpoint of block %d", toBlock)
+		}
+		fromBlock = toBlock + 1
+	}
+}
+
+// resumeBlock return the block after the last checkpoint, or the start block if there is none
+func (idx *Indexer) resumeBlock(ctx context.Context) (uint64, error) {
+	checkpoint, ok, err := idx.store.Load(ctx, idx.config.Name)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to load checkpoint")
+	}
+	if !ok {
+		return idx.config.StartBlock, nil
+	}
+	return checkpoint + 1, nil
+}
+
+// nextRange return the end of the next range starting at fromBlock, ok is false if there is no confirmed block yet
+func (idx *Indexer) nextRange(ctx context.Context, fromBlock uint64) (uint64, bool, error) {
+	head, err := idx.pool.GetLatestBlockContext(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	if head < idx.config.Confirmations {
+		return 0, false, nil
+	}
+	safeBlock := head - idx.config.Confirmations
+	if fromBlock > safeBlock {
+		return 0, false, nil
+	}
+	toBlock := idx.pool.GetToBlock(int64(fromBlock+idx.config.BatchSize-1), int64(safeBlock))
+	return uint64(toBlock), true, nil
+}
+
+// handle call the handler on a range, retrying with an exponential delay when it fails
+func (idx *Indexer) handle(ctx context.Context, fromBlock, toBlock uint64, logs []types.Log) error {
+	delay := idx.config.RetryDelay
+	for attempt := 0; ; attempt++ {
+		err := idx.handler(ctx, fromBlock, toBlock, logs)
+		if err == nil {
+			return nil
+		}
+		if attempt >= idx.config.MaxRetries {
+			return errors.Wrapf(err, "handler of indexer %s failed on block range [%d, %d]", idx.config.Name, fromBlock, toBlock)
+		}
+		log.FromContext(ctx).Errorf("Indexer %s handler error on block range [%d, %d], retry in %v: %v", idx.config.Name, fromBlock, toBlock, delay, err)
+		if err := client_pool.SleepContext(ctx, delay); err != nil {
+			return err
+		}
+		delay *= 2
+	}
+}
+
+// ignoreDone turn the errors caused by ctx being done into nil
+func ignoreDone(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,230 @@
+package indexer_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/duongtuttbn/toolkit/client_pool"
+	"github.com/duongtuttbn/toolkit/client_pool/rpctest"
+	"github.com/duongtuttbn/toolkit/indexer"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+var transferTopic = common.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+
+// handledRange is a range given to the handler with the blocks of its logs
+type handledRange struct {
+	from, to uint64
+	blocks   []uint64
+}
+
+func newPool(t *testing.T, chain *rpctest.Chain) *client_pool.ClientPool {
+	t.Helper()
+	server := rpctest.NewServer(chain)
+	t.Cleanup(server.Close)
+	pool, err := client_pool.NewBasicClientPool(client_pool.Config{
+		Endpoints: []client_pool.EndpointConfig{{URL: server.URL, Label: server.URL}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
+
+func addTransfers(chain *rpctest.Chain, blocks ...uint64) {
+	for _, block := range blocks {
+		chain.AddLogs(types.Log{
+			Address:     common.HexToAddress("0x1"),
+			Topics:      []common.Hash{transferTopic},
+			BlockNumber: block,
+			TxHash:      common.BigToHash(new(big.Int).SetUint64(block)),
+		})
+	}
+}
+
+func testConfig() indexer.Config {
+	return indexer.Config{
+		Name:         "transfers",
+		FilterQuery:  ethereum.FilterQuery{Topics: [][]common.Hash{{transferTopic}}},
+		BatchSize:    40,
+		PollInterval: 10 * time.Millisecond,
+		RetryDelay:   time.Millisecond,
+	}
+}
+
+// run start an indexer and record the ranges given to its handler, until a range ends at stopBlock.
+// fail is called before recording a range, the handler returns its error
+func run(
+	t *testing.T,
+	pool *client_pool.ClientPool,
+	store indexer.CheckpointStore,
+	cfg indexer.Config,
+	stopBlock uint64,
+	fail func(from, to uint64) error,
+) ([]handledRange, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ranges := make([]handledRange, 0)
+	handler := func(_ context.Context, fromBlock, toBlock uint64, logs []types.Log) error {
+		if fail != nil {
+			if err := fail(fromBlock, toBlock); err != nil {
+				return err
+			}
+		}
+		r := handledRange{from: fromBlock, to: toBlock}
+		for _, l := range logs {
+			r.blocks = append(r.blocks, l.BlockNumber)
+		}
+		ranges = append(ranges, r)
+		if toBlock == stopBlock {
+			cancel()
+		}
+		return nil
+	}
+	err := indexer.NewIndexer(pool, store, handler, cfg).Run(ctx)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		t.Fatalf("indexer did not reach block %d", stopBlock)
+	}
+	return ranges, err
+}
+
+func TestIndexerResumesAfterRestart(t *testing.T) {
+	chain := rpctest.NewChain(100)
+	addTransfers(chain, 10, 50, 90)
+	pool := newPool(t, chain)
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+
+	ranges, err := run(t, pool, indexer.NewFileCheckpointStore(path), testConfig(), 39, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranges) != 1 || ranges[0].from != 0 || ranges[0].to != 39 || len(ranges[0].blocks) != 1 {
+		t.Fatalf("got ranges %+v, want [0, 39] with the log of block 10", ranges)
+	}
+
+	// a new store on the same file resume after the checkpoint of the first run, the confirmations hold back the head
+	cfg := testConfig()
+	cfg.Confirmations = 10
+	ranges, err = run(t, pool, indexer.NewFileCheckpointStore(path), cfg, 90, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranges) != 2 || ranges[0].from != 40 || ranges[0].to != 79 || ranges[1].from != 80 || ranges[1].to != 90 {
+		t.Fatalf("got ranges %+v, want [40, 79] and [80, 90]", ranges)
+	}
+	if len(ranges[0].blocks) != 1 || ranges[0].blocks[0] != 50 || len(ranges[1].blocks) != 1 || ranges[1].blocks[0] != 90 {
+		t.Fatalf("got ranges %+v, want the logs of blocks 50 and 90", ranges)
+	}
+	block, ok, err := indexer.NewFileCheckpointStore(path).Load(context.Background(), cfg.Name)
+	if err != nil || !ok || block != 90 {
+		t.Fatalf("got checkpoint %d (found %v, error %v), want 90", block, ok, err)
+	}
+}
+
+func TestIndexerRetriesHandler(t *testing.T) {
+	chain := rpctest.NewChain(100)
+	pool := newPool(t, chain)
+	store := indexer.NewMemoryCheckpointStore()
+
+	// the handler fail twice on the second range, which is retried before moving on
+	attempts := make(map[uint64]int)
+	ranges, err := run(t, pool, store, testConfig(), 100, func(from, _ uint64) error {
+		attempts[from]++
+		if from == 40 && attempts[from] <= 2 {
+			return errors.New("database unavailable")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts[0] != 1 || attempts[40] != 3 || attempts[80] != 1 {
+		t.Fatalf("got attempts %v, want the failing range handled 3 times", attempts)
+	}
+	if len(ranges) != 3 || ranges[1].from != 40 || ranges[1].to != 79 {
+		t.Fatalf("got ranges %+v, want each range handled once successfully", ranges)
+	}
+
+	// once the retries are used up, Run fails without saving the checkpoint of the range
+	cfg := testConfig()
+	cfg.Name = "failing"
+	cfg.MaxRetries = 2
+	_, err = run(t, pool, store, cfg, 100, func(from, _ uint64) error {
+		if from == 40 {
+			return errors.New("invalid log")
+		}
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "invalid log") {
+		t.Fatalf("got error %v, want the handler error", err)
+	}
+	block, ok, _ := store.Load(context.Background(), cfg.Name)
+	if !ok || block != 39 {
+		t.Fatalf("got checkpoint %d (found %v), want 39", block, ok)
+	}
+}
+
+func TestFileCheckpointStore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "checkpoints.json")
+	store := indexer.NewFileCheckpointStore(path)
+	if _, ok, err := store.Load(ctx, "transfers"); ok || err != nil {
+		t.Fatalf("got a checkpoint (error %v) before any save", err)
+	}
+
+	for key, block := range map[string]uint64{"transfers": 100, "swaps": 200} {
+		if err := store.Save(ctx, key, block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Save(ctx, "transfers", 150); err != nil {
+		t.Fatal(err)
+	}
+
+	// another store on the same file see every checkpoint, the last save of a key replacing the previous one
+	reopened := indexer.NewFileCheckpointStore(path)
+	for key, want := range map[string]uint64{"transfers": 150, "swaps": 200} {
+		block, ok, err := reopened.Load(ctx, key)
+		if err != nil || !ok || block != want {
+			t.Fatalf("got checkpoint %d of %s (found %v, error %v), want %d", block, key, ok, err, want)
+		}
+	}
+
+	// the file is replaced by a complete one on each save, no temporary file is left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "checkpoints.json" {
+		t.Fatalf("got files %v, want only the checkpoint file", entries)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpoints := make(map[string]uint64)
+	if err := json.Unmarshal(data, &checkpoints); err != nil || len(checkpoints) != 2 {
+		t.Fatalf("got checkpoint file %q (error %v), want the 2 checkpoints", data, err)
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := reopened.Load(ctx, "transfers"); err == nil {
+		t.Fatal("loaded a corrupted checkpoint file")
+	}
+	if err := reopened.Save(ctx, "transfers", 300); err == nil {
+		t.Fatal("saved over a corrupted checkpoint file, dropping the other checkpoints")
+	}
+}
@@ -44,6 +44,10 @@ func (cfg Config) Build() *logrus.Logger {
 }
 
 func newRlog() *rlog {
+	// Root may be used before any Config is built, fall back to the logrus standard logger
+	if logger == nil {
+		return &rlog{entry: logrus.NewEntry(logrus.StandardLogger())}
+	}
 	return &rlog{
 		entry: logrus.NewEntry(logger),
 	}
@@ -0,0 +1,33 @@
+package model
+
+import "math/big"
+
+type PoolType string
+
+const (
+	PoolTypeUniswapV2 PoolType = "uniswap_v2"
+	PoolTypeUniswapV3 PoolType = "uniswap_v3"
+	PoolTypeUniswapV4 PoolType = "uniswap_v4"
+	PoolTypeCurve     PoolType = "curve"
+	PoolTypeBalancer  PoolType = "balancer"
+)
+
+// PoolState describe a liquidity pool of any supported DEX, fields that do not apply to its type are left empty
+type PoolState struct {
+	// PoolAddress is the pool contract, empty for Uniswap V4 pools that all live in the PoolManager
+	PoolAddress string `json:"pool_address"`
+	// PoolID identify Uniswap V4 and Balancer pools
+	PoolID string   `json:"pool_id,omitempty"`
+	Type   PoolType `json:"type"`
+	Tokens []string `json:"tokens"`
+	// Reserves are the token balances of the pool, in the order of Tokens, for Uniswap V2, Curve and Balancer
+	Reserves []*big.Int `json:"reserves,omitempty"`
+	// Fee is the swap fee in hundredths of a bip for Uniswap V3 and V4
+	Fee          uint32   `json:"fee,omitempty"`
+	TickSpacing  int32    `json:"tick_spacing,omitempty"`
+	Tick         int32    `json:"tick,omitempty"`
+	SqrtPriceX96 *big.Int `json:"sqrt_price_x96,omitempty"`
+	Liquidity    *big.Int `json:"liquidity,omitempty"`
+	// Hooks is the hooks contract of a Uniswap V4 pool
+	Hooks string `json:"hooks,omitempty"`
+}
@@ -1,11 +1,19 @@
 package model
 
+import "math/big"
+
 type TokenInfo struct {
 	TokenAddress     string  `json:"token_address"`
 	TokenName        string  `json:"token_name"`
 	TokenSymbol      string  `json:"token_symbol"`
 	ContractDecimals int64   `json:"contract_decimals"`
 	TotalSupply      float64 `json:"total_supply"`
+	// TotalSupplyRaw is the total supply in the smallest unit, before TotalSupply is scaled and rounded
+	TotalSupplyRaw *big.Int `json:"total_supply_raw"`
+	// Implementation is the address of the proxy implementation, when some fields had to be read from it
+	Implementation string `json:"implementation,omitempty"`
+	// Unresolved list the fields (name, symbol, decimals, totalSupply) that could not be read from the contract
+	Unresolved []string `json:"unresolved,omitempty"`
 }
 
 type LiquidityPoolInfo struct {